@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	g2c "github.com/charles-m-knox/ghost-to-castopod/pkg/lib"
+)
+
+// runConfig prints the effective config, after environment variable
+// overrides and defaults, with its secrets redacted. It doesn't connect to
+// either database.
+func runConfig(ctx context.Context, c *g2c.Config, args []string) error {
+	fs := newFlagSet("config", "")
+	flagEnv := fs.Bool("env", false, "list the environment variables that override the config instead")
+
+	err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if fs.NArg() > 0 {
+		return usageError{fmt.Errorf("unexpected arguments: %v", fs.Args())}
+	}
+
+	if *flagEnv {
+		for _, name := range g2c.EnvVars() {
+			fmt.Println(name)
+		}
+
+		return nil
+	}
+
+	b, err := json.MarshalIndent(c.Redacted(), "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %v", err)
+	}
+
+	fmt.Println(string(b))
+
+	return nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	g2c "github.com/charles-m-knox/ghost-to-castopod/pkg/lib"
+)
+
+// runDaemon syncs immediately and then repeatedly until ctx is canceled. The
+// next sync is only scheduled once the previous one has finished, so syncs
+// never overlap no matter how long one takes.
+func runDaemon(ctx context.Context, c *g2c.Config, args []string) error {
+	fs := newFlagSet("daemon", "")
+	flagInterval := fs.Duration("interval", time.Duration(c.Daemon.Interval), "how long to wait between syncs")
+	flagJitter := fs.Duration("jitter", time.Duration(c.Daemon.Jitter), "up to this much random time is added to each interval")
+	flagShutdown := fs.Duration("shutdown-timeout", time.Duration(c.Daemon.ShutdownTimeout), "when stopping, how long to wait for an in-flight sync before rolling it back")
+
+	err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if fs.NArg() > 0 {
+		return usageError{fmt.Errorf("unexpected arguments: %v", fs.Args())}
+	}
+
+	if *flagInterval <= 0 || *flagJitter < 0 || *flagShutdown < 0 {
+		return usageError{fmt.Errorf("-interval must be positive, and -jitter and -shutdown-timeout cannot be negative")}
+	}
+
+	dbs, err := openDatabases(c)
+	if err != nil {
+		return err
+	}
+
+	defer dbs.Close()
+
+	err = resolvePodcasts(ctx, c, dbs)
+	if err != nil {
+		return err
+	}
+
+	m := g2c.NewMetrics()
+
+	if c.Metrics.Listen != "" {
+		// the default is based on the interval that is actually used, which
+		// may come from the flags
+		maxAge := time.Duration(c.Metrics.MaxAge)
+		if maxAge == 0 {
+			maxAge = 3 * (*flagInterval + *flagJitter)
+		}
+
+		srv := &http.Server{Addr: c.Metrics.Listen, Handler: m.Handler(maxAge)}
+
+		go func() {
+			slog.Info("serving metrics", "listen", c.Metrics.Listen)
+
+			err := srv.ListenAndServe()
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("failed to serve metrics", "err", err)
+			}
+		}()
+
+		defer srv.Shutdown(context.WithoutCancel(ctx))
+	}
+
+	slog.Info("starting daemon", "interval", *flagInterval, "jitter", *flagJitter)
+
+	for {
+		runCtx, cancel := shutdownContext(ctx, *flagShutdown)
+		start := time.Now()
+		p := g2c.SyncPlan{}
+
+		err = withRunLock(runCtx, c, dbs, func() error {
+			var err error
+
+			p, err = getSyncPlan(runCtx, c, dbs, m)
+			if err != nil {
+				return err
+			}
+
+			slog.Info("planned sync", "creates", len(p.Creates), "status_changes", len(p.StatusChanges), "unchanged", len(p.Unchanged), "skipped", len(p.Skipped))
+
+			return applyPlan(runCtx, c, dbs, p, false)
+		})
+
+		m.RecordRun(start, p, err)
+
+		if errors.Is(err, g2c.ErrLockHeld) {
+			slog.Warn("skipping this sync", "err", err)
+		} else if err != nil {
+			slog.Error("sync failed", "err", err)
+		}
+
+		cancel()
+
+		wait := *flagInterval
+		if *flagJitter > 0 {
+			wait += rand.N(*flagJitter)
+		}
+
+		select {
+		case <-ctx.Done():
+			slog.Info("stopping daemon")
+			return nil
+		case <-time.After(wait):
+		}
+	}
+}
+
+// shutdownContext returns a context that is not canceled along with ctx, so
+// that an in-flight sync can finish when asked to stop, but that is canceled
+// once grace has passed since ctx was canceled. Canceling it makes an
+// in-flight write roll back.
+func shutdownContext(ctx context.Context, grace time.Duration) (context.Context, context.CancelFunc) {
+	runCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+
+	go func() {
+		select {
+		case <-runCtx.Done():
+			return
+		case <-ctx.Done():
+			slog.Info("waiting for the in-flight sync to finish", "timeout", grace)
+		}
+
+		select {
+		case <-runCtx.Done():
+		case <-time.After(grace):
+			slog.Warn("the in-flight sync did not finish in time; canceling it")
+			cancel()
+		}
+	}()
+
+	return runCtx, cancel
+}
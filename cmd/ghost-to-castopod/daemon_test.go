@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestShutdownContext(t *testing.T) {
+	t.Parallel()
+
+	ctx, stop := context.WithCancel(context.Background())
+
+	runCtx, cancel := shutdownContext(ctx, 50*time.Millisecond)
+	defer cancel()
+
+	stop()
+
+	// the in-flight run keeps going for the grace period...
+	select {
+	case <-runCtx.Done():
+		t.Fatalf("run context was canceled immediately")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	// ...and is then canceled so that its write rolls back
+	select {
+	case <-runCtx.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("run context was not canceled after the grace period")
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+
+	g2c "github.com/charles-m-knox/ghost-to-castopod/pkg/lib"
+	_ "github.com/go-sql-driver/mysql"
+)
+
+// openDB opens a mysql connection pool. No connection is made until the pool
+// is first used.
+func openDB(constr string) (*sql.DB, error) {
+	db, err := sql.Open("mysql", constr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open db: %v", err)
+	}
+
+	db.SetConnMaxLifetime(time.Minute * 3)
+	db.SetMaxOpenConns(10)
+	db.SetMaxIdleConns(10)
+
+	return db, nil
+}
+
+// databases holds the connection pools used by the subcommands. ghost is nil
+// when memberships are read from the Ghost Admin API.
+type databases struct {
+	ghost    *sql.DB
+	castopod *sql.DB
+}
+
+func openDatabases(c *g2c.Config) (databases, error) {
+	var dbs databases
+	var err error
+
+	if c.GhostAdminAPI.URL == "" {
+		dbs.ghost, err = openDB(c.SQLConnectionString)
+		if err != nil {
+			return dbs, fmt.Errorf("ghost: %v", err)
+		}
+	}
+
+	dbs.castopod, err = openDB(c.CastopodConfig.SQLConnectionString)
+	if err != nil {
+		dbs.Close()
+		return dbs, fmt.Errorf("castopod: %v", err)
+	}
+
+	return dbs, nil
+}
+
+func (dbs databases) Close() {
+	if dbs.ghost != nil {
+		dbs.ghost.Close()
+	}
+
+	if dbs.castopod != nil {
+		dbs.castopod.Close()
+	}
+}
+
+// resolvePodcasts resolves the podcast handles in the config against the
+// Castopod database. It must be called before reconciling.
+func resolvePodcasts(ctx context.Context, c *g2c.Config, dbs databases) error {
+	err := c.ResolvePodcastHandles(ctx, dbs.castopod)
+	if err != nil {
+		return fmt.Errorf("failed to resolve podcast handles: %v", err)
+	}
+
+	return nil
+}
+
+// getSyncPlan reads every Ghost membership and Castopod subscription and
+// reconciles them. m may be nil.
+func getSyncPlan(ctx context.Context, c *g2c.Config, dbs databases, m *g2c.Metrics) (g2c.SyncPlan, error) {
+	gms, err := c.ReadGhostMemberships(ctx, dbs.ghost)
+	if err != nil {
+		return g2c.SyncPlan{}, g2c.StageError{Stage: g2c.StageReadGhost, Err: err}
+	}
+
+	m.RecordGhostMembers(gms)
+
+	cms, err := c.ReadCastopodSubscriptions(ctx, dbs.castopod)
+	if err != nil {
+		return g2c.SyncPlan{}, g2c.StageError{Stage: g2c.StageReadCastopod, Err: err}
+	}
+
+	return c.GetSyncPlan(gms, cms), nil
+}
+
+// withRunLock runs fn while holding the run lock on the Castopod database, so
+// that syncs on other hosts wait for it or give up. See [g2c.Config.AcquireRunLock].
+func withRunLock(ctx context.Context, c *g2c.Config, dbs databases, fn func() error) error {
+	l, err := c.AcquireRunLock(ctx, dbs.castopod)
+	if err != nil {
+		return g2c.StageError{Stage: g2c.StageLock, Err: err}
+	}
+
+	defer func() {
+		err := l.Release(ctx)
+		if err != nil {
+			slog.Error("failed to release the run lock", "err", err)
+		}
+	}()
+
+	return fn()
+}
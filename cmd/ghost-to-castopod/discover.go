@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	g2c "github.com/charles-m-knox/ghost-to-castopod/pkg/lib"
+)
+
+// starterConfig is the config written by discover. It only has the fields
+// that every config needs, in the order that they are usually filled in.
+type starterConfig struct {
+	SQLConnectionString string `json:"sqlConnectionString"`
+	CastopodConfig      struct {
+		SQLConnectionString string `json:"sqlConnectionString"`
+		CreatedBy           uint   `json:"createdBy"`
+		UpdatedBy           uint   `json:"updatedBy"`
+	} `json:"castopodConfig"`
+	Plans           map[string][]string `json:"plans"`
+	BlessedAccounts map[string][]string `json:"blessedAccounts"`
+}
+
+// runDiscover lists the Ghost plans and Castopod podcasts that can be
+// configured, followed by a starter config with every plan ID filled in.
+func runDiscover(ctx context.Context, c *g2c.Config, args []string) error {
+	fs := newFlagSet("discover", "")
+	flagOut := fs.String("o", "", "write the starter config to this file instead of printing it; the file must not exist")
+
+	err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if fs.NArg() > 0 {
+		return usageError{fmt.Errorf("unexpected arguments: %v", fs.Args())}
+	}
+
+	dbs, err := openDatabases(c)
+	if err != nil {
+		return err
+	}
+
+	defer dbs.Close()
+
+	if dbs.ghost == nil {
+		return errors.New("discover reads plans from the ghost database, so sqlConnectionString must be configured instead of ghostAdminAPI")
+	}
+
+	plans, err := c.ReadGhostPlans(ctx, dbs.ghost)
+	if err != nil {
+		return err
+	}
+
+	podcasts, err := g2c.ReadCastopodPodcasts(ctx, dbs.castopod)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "ghost plans:\n")
+	fmt.Fprintf(tw, "  PLAN ID\tNICKNAME\tPRICE\tACTIVE MEMBERS\tALL MEMBERS\n")
+
+	for _, p := range plans {
+		fmt.Fprintf(tw, "  %v\t%v\t%v\t%v\t%v\n", p.ID, p.Nickname, p.Price(), p.ActiveMembers, p.Members)
+	}
+
+	fmt.Fprintf(tw, "\ncastopod podcasts:\n")
+	fmt.Fprintf(tw, "  ID\tHANDLE\tTITLE\tPREMIUM BY DEFAULT\tPREMIUM EPISODES\n")
+
+	for _, p := range podcasts {
+		fmt.Fprintf(tw, "  %v\t@%v\t%v\t%v\t%v\n", p.ID, p.Handle, p.Title, p.PremiumByDefault, p.HasPremiumEpisodes)
+	}
+
+	err = tw.Flush()
+	if err != nil {
+		return err
+	}
+
+	// the real connection strings, with their passwords, are only written to
+	// a file that only its owner can read; the printed config has them
+	// redacted, since stdout may end up in a terminal log or a ticket
+	dsns := *c
+	if *flagOut == "" {
+		dsns = c.Redacted()
+	}
+
+	sc := starterConfig{
+		SQLConnectionString: dsns.SQLConnectionString,
+		Plans:               make(map[string][]string),
+		BlessedAccounts:     make(map[string][]string),
+	}
+
+	sc.CastopodConfig.SQLConnectionString = dsns.CastopodConfig.SQLConnectionString
+	sc.CastopodConfig.CreatedBy = c.CastopodConfig.CreatedBy
+	sc.CastopodConfig.UpdatedBy = c.CastopodConfig.UpdatedBy
+
+	for _, p := range plans {
+		sc.Plans[p.ID] = []string{}
+	}
+
+	b, err := json.MarshalIndent(sc, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal starter config: %v", err)
+	}
+
+	if *flagOut == "" {
+		fmt.Printf("\nstarter config, with the database passwords replaced by *** (-o writes them to a file); add the handles of the podcasts that each plan grants, such as \"@mypodcast\":\n\n%v\n", string(b))
+		return nil
+	}
+
+	// the config contains database passwords, so it is only readable by its
+	// owner, and an existing config is never overwritten
+	f, err := os.OpenFile(*flagOut, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create starter config: %v", err)
+	}
+
+	_, err = f.Write(append(b, '\n'))
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write starter config to %v: %v", *flagOut, err)
+	}
+
+	err = f.Close()
+	if err != nil {
+		return fmt.Errorf("failed to write starter config to %v: %v", *flagOut, err)
+	}
+
+	fmt.Printf("\nwrote a starter config to %v; add the handles of the podcasts that each plan grants, such as \"@mypodcast\"\n", *flagOut)
+
+	return nil
+}
@@ -0,0 +1,16 @@
+module github.com/charles-m-knox/ghost-to-castopod/cmd/ghost-to-castopod
+
+go 1.23.0
+
+require (
+	github.com/charles-m-knox/ghost-to-castopod v0.0.5
+	github.com/go-sql-driver/mysql v1.8.1
+)
+
+require (
+	filippo.io/edwards25519 v1.1.0 // indirect
+	github.com/charles-m-knox/go-castopod v0.0.5 // indirect
+)
+
+// the command is built against the library in this repository
+replace github.com/charles-m-knox/ghost-to-castopod => ../..
@@ -0,0 +1,165 @@
+// Command ghost-to-castopod synchronizes Ghost memberships to Castopod premium
+// podcast subscriptions. Run it without arguments for usage.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	g2c "github.com/charles-m-knox/ghost-to-castopod/pkg/lib"
+)
+
+// Exit statuses.
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+	// Another run holds the lock on the Castopod database.
+	exitLockHeld = 3
+	// The plan exceeded the safety limits and was not applied.
+	exitRefused = 4
+)
+
+// command is a single subcommand. run receives the arguments that follow the
+// subcommand's name.
+type command struct {
+	name    string
+	summary string
+	run     func(ctx context.Context, c *g2c.Config, args []string) error
+}
+
+// commands are listed in the order that they appear in the usage.
+var commands = []command{
+	{"plan", "show the changes a sync would make, without writing anything", runPlan},
+	{"sync", "apply the changes to the Castopod database", runSync},
+	{"daemon", "sync repeatedly on a schedule until stopped", runDaemon},
+	{"validate", "check the config and the connections to Ghost and Castopod", runValidate},
+	{"discover", "list the Ghost plans and Castopod podcasts, and print a starter config", runDiscover},
+	{"config", "print the effective config, with secrets redacted", runConfig},
+	{"status", "show a single member's Ghost memberships and Castopod entitlements", runStatus},
+	{"rollback", "restore the subscriptions saved in a snapshot by an earlier sync", runRollback},
+	{"query", "show the history of subscription changes from the audit log", runQuery},
+	{"resend", "send the notifications that earlier runs couldn't send again", runResend},
+}
+
+// usageError is returned by a subcommand when it was invoked incorrectly.
+type usageError struct{ error }
+
+func printUsage(w io.Writer, fs *flag.FlagSet) {
+	fmt.Fprintf(w, "usage: ghost-to-castopod [flags] <command> [command flags]\n\ncommands:\n")
+
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-10v %v\n", cmd.name, cmd.summary)
+	}
+
+	fmt.Fprintf(w, "\nflags:\n")
+	fs.SetOutput(w)
+	fs.PrintDefaults()
+	fmt.Fprintf(w, "\nrun 'ghost-to-castopod <command> -h' for the command's flags.\n")
+}
+
+// newFlagSet returns a flag set for a subcommand that reports errors instead
+// of exiting.
+func newFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), strings.TrimSpace("usage: ghost-to-castopod "+name+" [flags] "+args))
+		fs.PrintDefaults()
+	}
+
+	return fs
+}
+
+// parseFlags parses a subcommand's flags, converting failures to a
+// [usageError].
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	err := fs.Parse(args)
+	if err != nil && !errors.Is(err, flag.ErrHelp) {
+		return usageError{err}
+	}
+
+	return err
+}
+
+func run(args []string) int {
+	fs := flag.NewFlagSet("ghost-to-castopod", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	flagConfig := fs.String("f", "config.json", "json file to use for loading configuration")
+	flagLogLevel := fs.String("log-level", "", "overrides log.level from the config: debug, info, warn or error")
+
+	err := fs.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		printUsage(os.Stdout, fs)
+		return exitOK
+	} else if err != nil || fs.NArg() == 0 {
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+
+		printUsage(os.Stderr, fs)
+		return exitUsage
+	}
+
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == fs.Arg(0) {
+			cmd = &commands[i]
+		}
+	}
+
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", fs.Arg(0))
+		printUsage(os.Stderr, fs)
+		return exitUsage
+	}
+
+	c, err := g2c.LoadConfig(*flagConfig)
+	if err == nil && *flagLogLevel != "" {
+		c.Log.Level = *flagLogLevel
+		err = c.Validate()
+	}
+
+	if err != nil {
+		slog.Error("failed to load config", "err", err)
+		return exitFailure
+	}
+
+	c.Logger = c.Log.NewLogger(os.Stderr)
+	slog.SetDefault(c.Logger)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err = cmd.run(ctx, &c, fs.Args()[1:])
+
+	var ue usageError
+
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+		return exitOK
+	case errors.Is(err, g2c.ErrLockHeld):
+		slog.Warn("another run holds the lock", "command", cmd.name, "err", err)
+		return exitLockHeld
+	case errors.Is(err, g2c.ErrTooManySuspensions):
+		slog.Error("refused to apply the plan", "command", cmd.name, "err", err)
+		return exitRefused
+	case errors.As(err, &ue):
+		fmt.Fprintf(os.Stderr, "%v: %v\n", cmd.name, err)
+		return exitUsage
+	default:
+		slog.Error("command failed", "command", cmd.name, "err", err)
+		return exitFailure
+	}
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
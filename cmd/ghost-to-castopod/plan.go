@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+
+	g2c "github.com/charles-m-knox/ghost-to-castopod/pkg/lib"
+)
+
+// writePlanFile writes the plan to f as json, if f is set.
+func writePlanFile(p g2c.SyncPlan, f string) error {
+	if f == "" {
+		return nil
+	}
+
+	b, err := p.JSON()
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(f, b, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to write plan to %v: %v", f, err)
+	}
+
+	return nil
+}
+
+func runPlan(ctx context.Context, c *g2c.Config, args []string) error {
+	fs := newFlagSet("plan", "")
+	flagJSON := fs.Bool("json", false, "print the plan as json instead of text")
+	flagOut := fs.String("o", "", "also write the plan to this file, as json")
+
+	err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if fs.NArg() > 0 {
+		return usageError{fmt.Errorf("unexpected arguments: %v", fs.Args())}
+	}
+
+	dbs, err := openDatabases(c)
+	if err != nil {
+		return err
+	}
+
+	defer dbs.Close()
+
+	err = resolvePodcasts(ctx, c, dbs)
+	if err != nil {
+		return err
+	}
+
+	p, err := getSyncPlan(ctx, c, dbs, nil)
+	if err != nil {
+		return err
+	}
+
+	err = writePlanFile(p, *flagOut)
+	if err != nil {
+		return err
+	}
+
+	err = c.CheckSuspensions(p)
+	if err != nil {
+		slog.Warn("sync would refuse this plan unless run with -override-safety", "err", err)
+	}
+
+	if *flagJSON {
+		b, err := p.JSON()
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(string(b))
+
+		return nil
+	}
+
+	fmt.Print(p)
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	g2c "github.com/charles-m-knox/ghost-to-castopod/pkg/lib"
+)
+
+// runQuery prints the entries in the audit log that match the given filters,
+// oldest first. It doesn't connect to either database.
+func runQuery(ctx context.Context, c *g2c.Config, args []string) error {
+	fs := newFlagSet("query", "")
+	flagEmail := fs.String("email", "", "only show changes to this email address")
+	flagPodcast := fs.Uint("podcast", 0, "only show changes to this podcast ID")
+	flagRun := fs.String("run", "", "only show changes made by this run ID")
+	flagJSON := fs.Bool("json", false, "print the matching entries as json lines instead of text")
+	flagFile := fs.String("audit-log", c.Audit.File, "the audit log to read")
+
+	err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if fs.NArg() > 0 {
+		return usageError{fmt.Errorf("unexpected arguments: %v", fs.Args())}
+	}
+
+	if *flagFile == "" {
+		return usageError{fmt.Errorf("audit.file is not configured; pass -audit-log")}
+	}
+
+	f, err := os.Open(*flagFile)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %v", err)
+	}
+
+	defer f.Close()
+
+	entries, err := g2c.ReadAuditLog(f, g2c.AuditFilter{
+		Email:     *flagEmail,
+		PodcastID: *flagPodcast,
+		RunID:     *flagRun,
+	})
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+
+	for _, e := range entries {
+		if *flagJSON {
+			err = enc.Encode(e)
+			if err != nil {
+				return fmt.Errorf("failed to print audit entry: %v", err)
+			}
+
+			continue
+		}
+
+		fmt.Println(e)
+	}
+
+	return nil
+}
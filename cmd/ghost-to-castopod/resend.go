@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	g2c "github.com/charles-m-knox/ghost-to-castopod/pkg/lib"
+)
+
+// runResend sends the notifications that earlier runs couldn't send again. It
+// doesn't connect to either database.
+func runResend(ctx context.Context, c *g2c.Config, args []string) error {
+	fs := newFlagSet("resend", "")
+
+	err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if fs.NArg() > 0 {
+		return usageError{fmt.Errorf("unexpected arguments: %v", fs.Args())}
+	}
+
+	sent, err := c.ResendUndelivered(ctx)
+
+	fmt.Printf("resent %v undelivered notifications\n", sent)
+
+	return err
+}
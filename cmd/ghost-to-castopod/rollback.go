@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+
+	g2c "github.com/charles-m-knox/ghost-to-castopod/pkg/lib"
+)
+
+// runRollback restores the subscriptions in a snapshot that was written by
+// sync or daemon, undoing that sync.
+func runRollback(ctx context.Context, c *g2c.Config, args []string) error {
+	fs := newFlagSet("rollback", "<snapshot>")
+
+	err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		return usageError{fmt.Errorf("expected exactly one snapshot file")}
+	}
+
+	s, err := g2c.LoadSnapshot(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	dbs, err := openDatabases(c)
+	if err != nil {
+		return err
+	}
+
+	defer dbs.Close()
+
+	return withRunLock(ctx, c, dbs, func() error {
+		results, err := c.RestoreSnapshot(ctx, dbs.castopod, s, filepath.Base(fs.Arg(0)))
+		if len(results) == 0 && err != nil {
+			return err
+		}
+
+		fmt.Printf("restored %v subscriptions and suspended %v created subscriptions from the snapshot taken at %v\n", len(s.Rows), len(s.Created), s.TakenAt.Format(g2c.CastopodTimeFormat))
+
+		// the audit log may have failed after the subscriptions were restored,
+		// in which case the cache still needs invalidating
+		errs := []error{err}
+
+		podcasts := g2c.AffectedPodcasts(results)
+
+		deleted, err := c.InvalidateCastopodCache(ctx, podcasts)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("subscriptions were restored, but failed to invalidate the castopod cache: %v", err))
+		} else if c.CastopodConfig.Redis.Addr != "" {
+			slog.Info("invalidated castopod cache", "podcasts", podcasts, "keys", deleted)
+		}
+
+		return errors.Join(errs...)
+	})
+}
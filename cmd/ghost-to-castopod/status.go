@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	g2c "github.com/charles-m-knox/ghost-to-castopod/pkg/lib"
+)
+
+// runStatus prints a member's Ghost memberships followed by the part of the
+// sync plan that concerns them, without writing anything.
+func runStatus(ctx context.Context, c *g2c.Config, args []string) error {
+	fs := newFlagSet("status", "<email>")
+
+	err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		return usageError{fmt.Errorf("expected exactly one email address")}
+	}
+
+	email := fs.Arg(0)
+
+	dbs, err := openDatabases(c)
+	if err != nil {
+		return err
+	}
+
+	defer dbs.Close()
+
+	err = resolvePodcasts(ctx, c, dbs)
+	if err != nil {
+		return err
+	}
+
+	all, err := c.ReadGhostMemberships(ctx, dbs.ghost)
+	if err != nil {
+		return err
+	}
+
+	gms := []g2c.GhostMembership{}
+	for _, gm := range all {
+		if gm.Email == email {
+			gms = append(gms, gm)
+		}
+	}
+
+	cms, err := c.ReadCastopodSubscriptionsByEmail(ctx, dbs.castopod, email)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%v\n\nghost memberships:\n", email)
+
+	if len(gms) == 0 {
+		fmt.Println("  none")
+	}
+
+	for _, gm := range gms {
+		line := fmt.Sprintf("plan %v: %v", gm.PlanID, gm.Status)
+		if gm.TierID != "" {
+			line = fmt.Sprintf("tier %v: %v", gm.TierID, gm.Status)
+		}
+
+		if !gm.CurrentPeriodEnd.IsZero() {
+			line += ", period ends " + gm.CurrentPeriodEnd.Format(g2c.CastopodTimeFormat)
+		}
+
+		if gm.CancelAtPeriodEnd {
+			line += ", canceling"
+		}
+
+		fmt.Printf("  %v\n", line)
+	}
+
+	fmt.Printf("\ncastopod subscriptions: ")
+	fmt.Print(c.GetSyncPlan(gms, cms).ForEmail(email))
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	g2c "github.com/charles-m-knox/ghost-to-castopod/pkg/lib"
+)
+
+func runSync(ctx context.Context, c *g2c.Config, args []string) error {
+	fs := newFlagSet("sync", "")
+	flagOut := fs.String("o", "", "write the applied plan to this file, as json")
+	flagOverride := fs.Bool("override-safety", false, "apply the plan even if it suspends more subscriptions than the safety limits allow")
+
+	err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if fs.NArg() > 0 {
+		return usageError{fmt.Errorf("unexpected arguments: %v", fs.Args())}
+	}
+
+	dbs, err := openDatabases(c)
+	if err != nil {
+		return err
+	}
+
+	defer dbs.Close()
+
+	err = resolvePodcasts(ctx, c, dbs)
+	if err != nil {
+		return err
+	}
+
+	return withRunLock(ctx, c, dbs, func() error {
+		p, err := getSyncPlan(ctx, c, dbs, nil)
+		if err != nil {
+			return err
+		}
+
+		err = writePlanFile(p, *flagOut)
+		if err != nil {
+			return err
+		}
+
+		// the full plan lists every subscriber's email address, so only the
+		// summary is logged; use plan or -o to see the whole plan
+		slog.Info("planned sync", "summary", p.Summary())
+
+		return applyPlan(ctx, c, dbs, p, *flagOverride)
+	})
+}
+
+// applyPlan writes the plan's changes to the Castopod database, after
+// reporting any flagged orphans. Unless override is set, a plan that exceeds
+// the safety limits is written to a file for review instead. Before anything
+// is written, a snapshot of the rows that will change is saved for the
+// rollback command.
+func applyPlan(ctx context.Context, c *g2c.Config, dbs databases, p g2c.SyncPlan, override bool) error {
+	for _, e := range p.Unchanged {
+		if e.Orphaned && e.NewStatus == g2c.CastopodStatusActive {
+			slog.Warn("flagged subscription has no ghost membership but is still active", g2c.LogKeyEmail, e.Email, "podcast_id", e.PodcastID)
+		}
+	}
+
+	if !p.HasChanges() {
+		slog.Info("no changes are needed")
+		return nil
+	}
+
+	err := c.CheckSuspensions(p)
+	if err != nil && override {
+		slog.Warn("overriding the safety limits", "err", err)
+	} else if err != nil {
+		f, werr := c.WriteRefusedPlan(p, time.Now())
+		if werr != nil {
+			return g2c.StageError{Stage: g2c.StageSafety, Err: errors.Join(err, werr)}
+		}
+
+		return g2c.StageError{Stage: g2c.StageSafety, Err: fmt.Errorf("refusing to sync, review the plan in %v and run sync with -override-safety if it is correct: %w", f, err)}
+	}
+
+	f, err := c.WriteSnapshot(p.Snapshot(time.Now()))
+	if err != nil {
+		return g2c.StageError{Stage: g2c.StageSnapshot, Err: err}
+	}
+
+	slog.Info("wrote snapshot", "file", f)
+
+	_, err = c.ApplyCastopodSubscriptions(ctx, dbs.castopod, p.Subscriptions())
+
+	return err
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	g2c "github.com/charles-m-knox/ghost-to-castopod/pkg/lib"
+)
+
+// runValidate checks every connection, and the config against the live
+// databases, and reports all failures together. The config itself has already
+// been validated by [g2c.LoadConfig] by the time this runs.
+func runValidate(ctx context.Context, c *g2c.Config, args []string) error {
+	fs := newFlagSet("validate", "")
+
+	err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if fs.NArg() > 0 {
+		return usageError{fmt.Errorf("unexpected arguments: %v", fs.Args())}
+	}
+
+	fmt.Println("config: ok")
+
+	dbs, err := openDatabases(c)
+	if err != nil {
+		return err
+	}
+
+	defer dbs.Close()
+
+	checks := []struct {
+		name  string
+		check func() error
+	}{
+		{"ghost", func() error {
+			if dbs.ghost == nil {
+				return c.CheckGhostAdminAPI(ctx, nil)
+			}
+
+			return g2c.CheckGhostDatabase(ctx, dbs.ghost)
+		}},
+		{"castopod", func() error { return g2c.CheckCastopodDatabase(ctx, dbs.castopod) }},
+		{"config against ghost and castopod", func() error { return c.ValidateAgainstDatabases(ctx, dbs.ghost, dbs.castopod) }},
+	}
+
+	failed := 0
+
+	for _, ch := range checks {
+		err := ch.check()
+		if err != nil {
+			// checks that find several problems report one per line
+			fmt.Printf("%v: %v\n", ch.name, strings.ReplaceAll(err.Error(), "\n", "\n  "))
+			failed++
+
+			continue
+		}
+
+		fmt.Printf("%v: ok\n", ch.name)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%v of %v checks failed", failed, len(checks))
+	}
+
+	return nil
+}
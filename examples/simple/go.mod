@@ -11,3 +11,6 @@ require (
 	filippo.io/edwards25519 v1.1.0 // indirect
 	github.com/charles-m-knox/go-castopod v0.0.5 // indirect
 )
+
+// the example is built against the library in this repository
+replace github.com/charles-m-knox/ghost-to-castopod => ../..
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
+	"net/http"
 	"os"
 	"strings"
 	"time"
@@ -14,15 +17,21 @@ import (
 )
 
 var (
-	flagConfig  string
-	flagTest    bool
-	flagOutFile string
+	flagConfig   string
+	flagTest     bool
+	flagOutFile  string
+	flagWebhook  bool
+	flagPlanFile string
+	flagOverride bool
 )
 
 func parseFlags() {
 	flag.StringVar(&flagConfig, "f", "config.json", "json file to use for loading configuration")
 	flag.BoolVar(&flagTest, "test", false, "connect read-only and perform a dry run")
-	flag.StringVar(&flagOutFile, "o", "", "a file to write the database query to (can combine with -test to allow manual editing of the query)")
+	flag.StringVar(&flagOutFile, "o", "", "a file to write the database statement and its per-row arguments to (can combine with -test to review the changes)")
+	flag.StringVar(&flagPlanFile, "p", "", "a file to write the sync plan to, as json, listing every create, status change, unchanged subscription and skipped input with its reason")
+	flag.BoolVar(&flagOverride, "override-safety", false, "write the changes even if they suspend more subscriptions than the safety limits allow")
+	flag.BoolVar(&flagWebhook, "webhooks", false, "instead of a full sync, listen for Ghost member webhooks and sync each member as they change")
 	flag.Parse()
 }
 
@@ -46,21 +55,10 @@ func getDB(constr string, readonly bool) *sql.DB {
 	return db
 }
 
-func main() {
-	parseFlags()
-
-	c, err := g2c.LoadConfig(flagConfig)
-	if err != nil {
-		log.Fatalf("failed to load config: %v", err.Error())
-	}
-
+// getGhostMemberships reads every Ghost membership directly from the Ghost
+// database.
+func getGhostMemberships(c g2c.Config) []g2c.GhostMembership {
 	ghost := getDB(c.SQLConnectionString, true)
-	castopod := getDB(c.CastopodConfig.SQLConnectionString, true)
-
-	var castopodWrite *sql.DB
-	if !flagTest {
-		castopodWrite = getDB(c.CastopodConfig.SQLConnectionString, false)
-	}
 
 	rows, err := ghost.Query(g2c.GHOST_MEMBERSHIP_QUERY)
 	if err != nil {
@@ -78,8 +76,106 @@ func main() {
 		}
 
 		gms = append(gms, membership)
+	}
+
+	tierRows, err := ghost.Query(g2c.GHOST_TIER_MEMBERSHIP_QUERY)
+	if err != nil {
+		log.Fatalf("failed to query tier memberships from db: %v", err.Error())
+	}
+
+	defer tierRows.Close()
+
+	for tierRows.Next() {
+		membership, err := c.GetGhostTierMembership(tierRows)
+		if err != nil {
+			log.Fatalf("failed to get ghost tier membership from row: %v", err.Error())
+		}
+
+		gms = append(gms, membership)
+	}
 
-		log.Println(membership)
+	return gms
+}
+
+// serveWebhooks listens for Ghost member webhooks until the process is stopped.
+func serveWebhooks(c g2c.Config) {
+	if c.Webhooks.Secret == "" {
+		log.Fatalf("webhooks.secret must be configured to receive webhooks")
+	}
+
+	castopodWrite := getDB(c.CastopodConfig.SQLConnectionString, false)
+
+	err := c.ResolvePodcastHandles(context.Background(), castopodWrite)
+	if err != nil {
+		log.Fatalf("failed to resolve podcast handles: %v", err.Error())
+	}
+
+	log.Printf("listening for ghost webhooks on %v", c.Webhooks.Listen)
+
+	err = http.ListenAndServe(c.Webhooks.Listen, c.NewWebhookHandler(castopodWrite))
+	if err != nil {
+		log.Fatalf("failed to serve webhooks: %v", err.Error())
+	}
+}
+
+func main() {
+	parseFlags()
+
+	c, err := g2c.LoadConfig(flagConfig)
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err.Error())
+	}
+
+	// email addresses and tokens are redacted according to c.Log.Redact
+	c.Logger = c.Log.NewLogger(os.Stderr)
+	slog.SetDefault(c.Logger)
+
+	if flagWebhook {
+		serveWebhooks(c)
+		return
+	}
+
+	castopod := getDB(c.CastopodConfig.SQLConnectionString, true)
+
+	// podcasts can be configured by handle, such as "@mypodcast"
+	err = c.ResolvePodcastHandles(context.Background(), castopod)
+	if err != nil {
+		log.Fatalf("failed to resolve podcast handles: %v", err.Error())
+	}
+
+	var castopodWrite *sql.DB
+	if !flagTest {
+		castopodWrite = getDB(c.CastopodConfig.SQLConnectionString, false)
+
+		// hold the run lock from castopodConfig.lock until the changes are
+		// written, so that a container running on another host can't read
+		// and write in between. MySQL releases it when the process exits,
+		// including through log.Fatalf.
+		l, err := c.AcquireRunLock(context.Background(), castopodWrite)
+		if err != nil {
+			log.Fatalf("failed to acquire the run lock: %v", err.Error())
+		}
+
+		defer func() {
+			err := l.Release(context.Background())
+			if err != nil {
+				slog.Error("failed to release the run lock", "err", err)
+			}
+		}()
+	}
+
+	var gms []g2c.GhostMembership
+	if c.GhostAdminAPI.URL != "" {
+		gms, err = c.GetGhostMembershipsFromAdminAPI(context.Background(), nil)
+		if err != nil {
+			log.Fatalf("failed to get ghost memberships from the admin api: %v", err.Error())
+		}
+	} else {
+		gms = getGhostMemberships(c)
+	}
+
+	for _, membership := range gms {
+		slog.Debug("read ghost membership", g2c.LogKeyEmail, membership.Email, "status", membership.Status, "plan_id", membership.PlanID, "tier_id", membership.TierID)
 	}
 
 	cs := []g2c.CastopodSubscription{}
@@ -91,75 +187,74 @@ func main() {
 		}
 
 		for rows.Next() {
-			var sub g2c.CastopodSubscription
-			var createdAt, updatedAt sql.NullString
-			err := rows.Scan(&sub.ID, &sub.PodcastID, &sub.Email, &sub.Token, &sub.Status, &sub.CreatedBy, &sub.UpdatedBy, &createdAt, &updatedAt)
+			sub, err := c.GetCastopodSubscription(rows)
 			if err != nil {
-				log.Fatalf("failed to scan row: %v", err.Error())
+				log.Fatalf("failed to get castopod subscription from row: %v", err.Error())
 			}
 
-			if createdAt.Valid {
-				sub.CreatedAt, err = time.Parse("2006-01-02 15:04:05", createdAt.String)
-				if err != nil {
-					log.Fatalf("failed to parse CreatedAt datetime: %v", err.Error())
-				}
-			}
+			cs = append(cs, sub)
 
-			if updatedAt.Valid {
-				sub.UpdatedAt, err = time.Parse("2006-01-02 15:04:05", updatedAt.String)
-				if err != nil {
-					log.Fatalf("failed to parse UpdatedAt datetime: %v", err.Error())
-				}
-			}
+			slog.Debug("read castopod subscription", g2c.LogKeyEmail, sub.Email, "podcast_id", sub.PodcastID, "status", sub.Status, g2c.LogKeyToken, sub.Token)
+		}
+	}
 
-			cs = append(cs, sub)
+	plan := c.GetSyncPlan(gms, cs)
+	slog.Info("planned sync", "summary", plan.Summary())
+
+	if flagPlanFile != "" {
+		b, err := plan.JSON()
+		if err != nil {
+			log.Fatalf("failed to render plan: %v", err.Error())
+		}
 
-			log.Println(sub)
+		err = os.WriteFile(flagPlanFile, b, 0o600)
+		if err != nil {
+			log.Fatalf("failed to write plan to %v: %v", flagPlanFile, err.Error())
 		}
 	}
 
-	results := c.GetCastopodSubscriptions(gms, cs)
-	var q strings.Builder
+	results := plan.Subscriptions()
+
+	for _, r := range results {
+		if r.Orphaned && r.Status == g2c.CastopodStatusActive {
+			slog.Warn("flagged subscription has no ghost membership but is still active", g2c.LogKeyEmail, r.Email, "podcast_id", r.PodcastID)
+		}
+	}
 
 	if len(results) == 0 {
 		log.Println("There were no results to update. Exiting.")
 		return
 	}
 
-	q.WriteString("INSERT INTO cp_subscriptions (podcast_id, email, token, status, created_by, updated_by, created_at, updated_at) VALUES \n")
-
-	lr := len(results) - 1
+	var q strings.Builder
 
-	changed := false
+	q.WriteString(g2c.CASTOPOD_SUBSCRIPTION_UPSERT)
+	q.WriteString("\n\n")
 
-	for i, r := range results {
+	for _, r := range results {
 		if !r.Changed {
 			continue
 		}
-		changed = true
 
-		finalComma := ","
-		if i == lr {
-			finalComma = ""
+		args := []string{}
+		for _, a := range r.Args() {
+			args = append(args, fmt.Sprintf("%q", fmt.Sprint(a)))
 		}
 
-		q.WriteString(fmt.Sprintf("(%v, '%v', '%v', '%v', %v, %v, '%v', '%v')%v \n", r.PodcastID, r.Email, r.Token, r.Status, r.CreatedBy, r.UpdatedBy, r.CreatedAt.Format("2006-01-02 15:04:05"), r.UpdatedAt.Format("2006-01-02 15:04:05"), finalComma))
+		q.WriteString(fmt.Sprintf("(%v)\n", strings.Join(args, ", ")))
 	}
 
-	if !changed {
+	if !plan.HasChanges() {
 		log.Println("done processing; no changes are needed since the last run. exiting.")
 		return
 	}
 
-	q.WriteString("ON DUPLICATE KEY UPDATE podcast_id = VALUES(podcast_id), email = VALUES(email), token = VALUES(token), status = VALUES(status), created_by = VALUES(created_by), updated_by = VALUES(updated_by), created_at = VALUES(created_at), updated_at = VALUES(updated_at);")
-
 	qq := q.String()
-	log.Printf("query: %v", qq)
 
 	if flagOutFile != "" {
-		err = os.WriteFile("out.txt", []byte(qq), 0o640)
+		err = os.WriteFile(flagOutFile, []byte(qq), 0o640)
 		if err != nil {
-			log.Fatalf("failed to write query to out.txt: %v", err.Error())
+			log.Fatalf("failed to write query to %v: %v", flagOutFile, err.Error())
 		}
 	}
 
@@ -168,14 +263,40 @@ func main() {
 		return
 	}
 
-	_, err = castopodWrite.Query(qq) // doesn't return any rows
+	err = c.CheckSuspensions(plan)
+	if err != nil && flagOverride {
+		slog.Warn("overriding the safety limits", "err", err)
+	} else if err != nil {
+		f, werr := c.WriteRefusedPlan(plan, time.Now())
+		if werr != nil {
+			log.Fatalf("refusing to write to castopod db: %v; also failed to save the refused plan: %v", err.Error(), werr.Error())
+		}
+
+		log.Fatalf("refusing to write to castopod db, review the plan in %v and run again with -override-safety if it is correct: %v", f, err.Error())
+	}
+
+	snapshot, err := c.WriteSnapshot(plan.Snapshot(time.Now()))
+	if err != nil {
+		log.Fatalf("failed to snapshot the castopod db: %v", err.Error())
+	}
+
+	log.Printf("saved the subscriptions that are about to change to %v.", snapshot)
+
+	written, err := c.ApplyCastopodSubscriptions(context.Background(), castopodWrite, results)
 	if err != nil {
 		log.Fatalf("failed to write to castopod db: %v", err.Error())
 	}
 
-	log.Println("done writing to the castopod database.")
+	log.Printf("done writing %v subscriptions to the castopod database.", len(written))
+
+	if c.CastopodConfig.Redis.Addr != "" {
+		log.Printf("invalidated the castopod cache for podcasts %v.", g2c.AffectedPodcasts(written))
+		return
+	}
+
 	fmt.Println("")
-	fmt.Println("Note: If you're running redis, please run:")
+	fmt.Println("Note: If you're running redis, please configure castopodConfig.redis")
+	fmt.Println("so that Castopod's cache is invalidated after each write, or run:")
 	fmt.Println("")
 	fmt.Println("redis-cli -a password_goes_here FLUSHALL")
 	fmt.Println("")
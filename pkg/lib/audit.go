@@ -0,0 +1,212 @@
+package ghosttocastopod
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// AuditConfig configures the audit log, which records every subscription
+// change that is written to Castopod. See [Config.WriteAuditLog].
+type AuditConfig struct {
+	// The JSONL file that changes are appended to. If empty, changes are not
+	// recorded.
+	File string `json:"file"`
+}
+
+// AuditEntry is a single line of the audit log, describing one subscription
+// change that was written to Castopod.
+type AuditEntry struct {
+	// Identifies the sync that made the change; every change written
+	// together shares a run ID.
+	RunID     string    `json:"runId"`
+	Time      time.Time `json:"time"`
+	Email     string    `json:"email"`
+	PodcastID uint      `json:"podcastId"`
+	// Empty for subscriptions that were created.
+	OldStatus string `json:"oldStatus,omitempty"`
+	NewStatus string `json:"newStatus"`
+	// In [CastopodTimeFormat]. Empty if the subscription never expires.
+	ExpiresAt string `json:"expiresAt,omitempty"`
+	// What decided the new status: "plan <id>", "tier <id>", one of the
+	// Reason constants such as [ReasonBlessedAccount] or [ReasonOrphaned], or
+	// "rollback <snapshot>" for [Config.RestoreSnapshot].
+	Cause string `json:"cause"`
+	// The status of the deciding Ghost membership, if there was one, such as
+	// "active", "canceled" or "comped".
+	GhostStatus string `json:"ghostStatus,omitempty"`
+	// See [CastopodSubscription.Reason].
+	Reason string `json:"reason"`
+}
+
+// cause describes what decided s's status for an [AuditEntry].
+func (s CastopodSubscription) cause() string {
+	switch {
+	case s.Membership.TierID != "":
+		return "tier " + s.Membership.TierID
+	case s.Membership.PlanID != "":
+		return "plan " + s.Membership.PlanID
+	}
+
+	return s.Reason
+}
+
+// NewAuditEntries returns an audit entry for every successfully written
+// subscription in results.
+func NewAuditEntries(runID string, now time.Time, results []WriteResult) []AuditEntry {
+	entries := []AuditEntry{}
+
+	for _, r := range results {
+		if r.Err != nil {
+			continue
+		}
+
+		s := r.Subscription
+
+		entries = append(entries, AuditEntry{
+			RunID:       runID,
+			Time:        now.UTC(),
+			Email:       s.Email,
+			PodcastID:   s.PodcastID,
+			OldStatus:   s.OldStatus,
+			NewStatus:   s.Status,
+			ExpiresAt:   formatExpiresAt(s),
+			Cause:       s.cause(),
+			GhostStatus: s.Membership.Status,
+			Reason:      s.Reason,
+		})
+	}
+
+	return entries
+}
+
+// newRunID returns a random identifier for a sync.
+func newRunID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// WriteAuditLog appends an entry for every successfully written subscription
+// in results to [AuditConfig.File], one JSON object per line. It does nothing
+// if the audit log isn't configured. The file is created if needed and is
+// only readable by its owner, since it contains email addresses.
+func (c *Config) WriteAuditLog(runID string, now time.Time, results []WriteResult) error {
+	if c.Audit.File == "" {
+		return nil
+	}
+
+	entries := NewAuditEntries(runID, now, results)
+	if len(entries) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+
+	enc := json.NewEncoder(&buf)
+	for _, e := range entries {
+		err := enc.Encode(e)
+		if err != nil {
+			return fmt.Errorf("failed to marshal audit entry: %v", err)
+		}
+	}
+
+	f, err := os.OpenFile(c.Audit.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log %v: %v", c.Audit.File, err)
+	}
+
+	// every entry is written at once, so that a run's entries are never
+	// interleaved with another process's
+	_, err = f.Write(buf.Bytes())
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write to audit log %v: %v", c.Audit.File, err)
+	}
+
+	err = f.Close()
+	if err != nil {
+		return fmt.Errorf("failed to close audit log %v: %v", c.Audit.File, err)
+	}
+
+	return nil
+}
+
+// AuditFilter selects audit entries in [ReadAuditLog]. Empty fields match
+// everything.
+type AuditFilter struct {
+	// Matched case-insensitively.
+	Email     string
+	PodcastID uint
+	RunID     string
+}
+
+// Matches returns true if e is selected by the filter.
+func (f AuditFilter) Matches(e AuditEntry) bool {
+	if f.Email != "" && !strings.EqualFold(f.Email, e.Email) {
+		return false
+	}
+
+	if f.PodcastID != 0 && f.PodcastID != e.PodcastID {
+		return false
+	}
+
+	return f.RunID == "" || f.RunID == e.RunID
+}
+
+// ReadAuditLog returns every entry in the audit log read from r that matches
+// the filter, oldest first.
+func ReadAuditLog(r io.Reader, f AuditFilter) ([]AuditEntry, error) {
+	entries := []AuditEntry{}
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for n := 1; sc.Scan(); n++ {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+
+		var e AuditEntry
+
+		err := json.Unmarshal(sc.Bytes(), &e)
+		if err != nil {
+			return entries, fmt.Errorf("failed to parse audit log line %v: %v", n, err)
+		}
+
+		if f.Matches(e) {
+			entries = append(entries, e)
+		}
+	}
+
+	err := sc.Err()
+	if err != nil {
+		return entries, fmt.Errorf("failed to read audit log: %v", err)
+	}
+
+	return entries, nil
+}
+
+// String renders the entry as human-readable text.
+func (e AuditEntry) String() string {
+	status := e.NewStatus
+	if e.OldStatus != "" && e.OldStatus != e.NewStatus {
+		status = e.OldStatus + " -> " + e.NewStatus
+	} else if e.OldStatus == "" {
+		status = "created " + e.NewStatus
+	}
+
+	if e.ExpiresAt != "" {
+		status += ", expires " + e.ExpiresAt
+	}
+
+	return fmt.Sprintf("%v run %v: %v podcast %v: %v (%v)", e.Time.Format(time.RFC3339), e.RunID, e.Email, e.PodcastID, status, e.Reason)
+}
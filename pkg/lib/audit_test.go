@@ -0,0 +1,106 @@
+package ghosttocastopod_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	ghosttocastopod "github.com/charles-m-knox/ghost-to-castopod/pkg/lib"
+)
+
+func TestAuditLog(t *testing.T) {
+	t.Parallel()
+
+	const member = "member@example.com"
+	const admin = "admin@example.com"
+
+	c := ghosttocastopod.Config{
+		Plans:           map[string][]uint{"plan": {1}},
+		Tiers:           map[string][]uint{"tier": {2}},
+		BlessedAccounts: map[string][]uint{admin: {1}},
+	}
+	c.ApplyDefaults()
+	c.Audit.File = filepath.Join(t.TempDir(), "audit.jsonl")
+
+	gms := []ghosttocastopod.GhostMembership{
+		{Email: member, Status: "canceled", PlanID: "plan"},
+		{Email: member, Status: "comped", TierID: "tier"},
+	}
+
+	cms := []ghosttocastopod.CastopodSubscription{
+		{Email: member, PodcastID: 1, Status: cActive},
+		{Email: member, PodcastID: 3, Status: cActive},
+		{Email: admin, PodcastID: 1, Status: cActive},
+	}
+
+	f := &fakeDB{}
+	db := f.open()
+	defer db.Close()
+
+	// run twice, so that two run IDs are appended to the same file
+	for range 2 {
+		_, err := c.ApplyCastopodSubscriptions(context.Background(), db, c.GetCastopodSubscriptions(gms, cms))
+		if err != nil {
+			t.Fatalf("failed to apply subscriptions: %v", err)
+		}
+	}
+
+	fi, err := os.Stat(c.Audit.File)
+	if err != nil || fi.Mode().Perm() != 0o600 {
+		t.Fatalf("audit log should only be readable by its owner: %v %v", fi, err)
+	}
+
+	b, err := os.ReadFile(c.Audit.File)
+	if err != nil {
+		t.Fatalf("failed to read audit log: %v", err)
+	}
+
+	entries, err := ghosttocastopod.ReadAuditLog(strings.NewReader(string(b)), ghosttocastopod.AuditFilter{})
+	if err != nil {
+		t.Fatalf("failed to parse audit log: %v", err)
+	}
+
+	// the admin's subscription is unchanged, so only the member's three
+	// changes are recorded by each run
+	if len(entries) != 6 || entries[0].RunID == entries[3].RunID || entries[0].RunID != entries[2].RunID {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+
+	want := map[uint]ghosttocastopod.AuditEntry{
+		1: {OldStatus: cActive, NewStatus: cSusp, Cause: "plan plan", GhostStatus: "canceled"},
+		2: {NewStatus: cActive, Cause: "tier tier", GhostStatus: "comped"},
+		3: {OldStatus: cActive, NewStatus: cSusp, Cause: ghosttocastopod.ReasonUnentitled},
+	}
+
+	for _, e := range entries[:3] {
+		w := want[e.PodcastID]
+		if e.Email != member || e.OldStatus != w.OldStatus || e.NewStatus != w.NewStatus || e.Cause != w.Cause || e.GhostStatus != w.GhostStatus || e.Time.IsZero() {
+			t.Errorf("entry mismatch for podcast %v, got %+v, want %+v", e.PodcastID, e, w)
+		}
+	}
+
+	tests := []struct {
+		filter ghosttocastopod.AuditFilter
+		want   int
+	}{
+		{ghosttocastopod.AuditFilter{Email: "MEMBER@example.com"}, 6},
+		{ghosttocastopod.AuditFilter{Email: admin}, 0},
+		{ghosttocastopod.AuditFilter{PodcastID: 2}, 2},
+		{ghosttocastopod.AuditFilter{RunID: entries[3].RunID}, 3},
+		{ghosttocastopod.AuditFilter{RunID: entries[3].RunID, PodcastID: 3}, 1},
+	}
+
+	for i, test := range tests {
+		got, err := ghosttocastopod.ReadAuditLog(strings.NewReader(string(b)), test.filter)
+		if err != nil || len(got) != test.want {
+			t.Errorf("test %v failed: got %v entries, want %v, err %v", i, len(got), test.want, err)
+		}
+	}
+
+	_, err = ghosttocastopod.ReadAuditLog(strings.NewReader("{}\nnot json\n"), ghosttocastopod.AuditFilter{})
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expected a parse error on line 2, got %v", err)
+	}
+}
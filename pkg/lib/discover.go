@@ -0,0 +1,104 @@
+package ghosttocastopod
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// GHOST_PLAN_SUMMARY_QUERY counts the members of every Stripe plan in Ghost by
+// subscription status, along with the plan's details. It is read by
+// [Config.ReadGhostPlans].
+const GHOST_PLAN_SUMMARY_QUERY = `SELECT
+  mscs.plan_id,
+  mscs.plan_nickname,
+  mscs.plan_amount,
+  mscs.plan_currency,
+  mscs.plan_interval,
+  mscs.status,
+  COUNT(DISTINCT msc.member_id) AS members
+FROM members_stripe_customers_subscriptions as mscs
+INNER JOIN members_stripe_customers as msc
+ON msc.customer_id = mscs.customer_id
+GROUP BY mscs.plan_id, mscs.plan_nickname, mscs.plan_amount, mscs.plan_currency, mscs.plan_interval, mscs.status
+`
+
+// GhostPlan summarizes a Stripe plan that Ghost members are subscribed to.
+type GhostPlan struct {
+	// The plan_id, which is the key to use in [Config.Plans].
+	ID       string
+	Nickname string
+	// In the currency's smallest unit, such as cents.
+	Amount   int64
+	Currency string
+	// Such as "month" or "year".
+	Interval string
+	// Members whose subscription to the plan has a status that grants access
+	// according to [Config.StatusPolicies], including grace periods.
+	ActiveMembers int
+	// Members with a subscription to the plan, whatever its status.
+	Members int
+}
+
+// Price renders the plan's price, such as "5.00 usd / month".
+func (p GhostPlan) Price() string {
+	return strings.TrimSpace(fmt.Sprintf("%d.%02d %v / %v", p.Amount/100, p.Amount%100, p.Currency, p.Interval))
+}
+
+// ReadGhostPlans reads every Stripe plan that Ghost members are subscribed to
+// from db using [GHOST_PLAN_SUMMARY_QUERY], sorted by plan ID. It is meant for
+// discovering the plan IDs to put in [Config.Plans].
+func (c *Config) ReadGhostPlans(ctx context.Context, db *sql.DB) ([]GhostPlan, error) {
+	ps := []GhostPlan{}
+
+	rows, err := db.QueryContext(ctx, GHOST_PLAN_SUMMARY_QUERY)
+	if err != nil {
+		return ps, fmt.Errorf("failed to query ghost plans: %v", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, nickname, currency, interval, status sql.NullString
+		var amount sql.NullInt64
+		var members int
+
+		err := rows.Scan(&id, &nickname, &amount, &currency, &interval, &status, &members)
+		if err != nil {
+			return ps, fmt.Errorf("failed to scan row: %v", err)
+		}
+
+		// a plan can appear once per status, and a member can have several
+		// subscriptions with different statuses, so the total is only an
+		// upper bound in that case
+		i := slices.IndexFunc(ps, func(p GhostPlan) bool { return p.ID == id.String })
+		if i < 0 {
+			ps = append(ps, GhostPlan{
+				ID:       id.String,
+				Nickname: nickname.String,
+				Amount:   amount.Int64,
+				Currency: currency.String,
+				Interval: interval.String,
+			})
+			i = len(ps) - 1
+		}
+
+		ps[i].Members += members
+
+		if c.statusPolicy(status.String) != StatusPolicySuspend {
+			ps[i].ActiveMembers += members
+		}
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return ps, fmt.Errorf("failed to read ghost plans: %v", err)
+	}
+
+	slices.SortFunc(ps, func(a, b GhostPlan) int { return cmp.Compare(a.ID, b.ID) })
+
+	return ps, nil
+}
@@ -0,0 +1,55 @@
+package ghosttocastopod_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+
+	ghosttocastopod "github.com/charles-m-knox/ghost-to-castopod/pkg/lib"
+)
+
+func TestReadGhostPlans(t *testing.T) {
+	t.Parallel()
+
+	f := &fakeDB{
+		rows: map[string]*fakeRows{
+			"plan_nickname": {
+				columns: []string{"plan_id", "plan_nickname", "plan_amount", "plan_currency", "plan_interval", "status", "members"},
+				values: [][]driver.Value{
+					{"price_b", "Monthly", int64(500), "usd", "month", "active", int64(3)},
+					{"price_b", "Monthly", int64(500), "usd", "month", "canceled", int64(2)},
+					{"price_a", nil, int64(5000), "eur", "year", "past_due", int64(1)},
+				},
+			},
+		},
+	}
+	db := f.open()
+	defer db.Close()
+
+	c := ghosttocastopod.Config{}
+	c.ApplyDefaults()
+
+	ps, err := c.ReadGhostPlans(context.Background(), db)
+	if err != nil {
+		t.Fatalf("failed to read plans: %v", err)
+	}
+
+	want := []ghosttocastopod.GhostPlan{
+		{ID: "price_a", Amount: 5000, Currency: "eur", Interval: "year", ActiveMembers: 1, Members: 1},
+		{ID: "price_b", Nickname: "Monthly", Amount: 500, Currency: "usd", Interval: "month", ActiveMembers: 3, Members: 5},
+	}
+
+	if len(ps) != len(want) {
+		t.Fatalf("plans length mismatch, got %+v", ps)
+	}
+
+	for i := range want {
+		if ps[i] != want[i] {
+			t.Errorf("plan %v mismatch, got %+v, want %+v", i, ps[i], want[i])
+		}
+	}
+
+	if ps[1].Price() != "5.00 usd / month" {
+		t.Errorf("unexpected price %q", ps[1].Price())
+	}
+}
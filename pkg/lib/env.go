@@ -0,0 +1,244 @@
+package ghosttocastopod
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// EnvPrefix is the prefix of every environment variable that overrides the
+// config. See [EnvVars].
+const EnvPrefix = "GHOST_TO_CASTOPOD_"
+
+// envVar is a config field that can be overridden by an environment variable.
+type envVar struct {
+	name string
+	// the field's json names, from the top of the config
+	path []string
+	// the field is a string, so the variable's value is never parsed as json
+	str bool
+}
+
+// envName converts a json field name such as "sqlConnectionString" to the
+// form used in environment variables, "SQL_CONNECTION_STRING".
+func envName(s string) string {
+	var sb strings.Builder
+
+	rs := []rune(s)
+	for i, r := range rs {
+		if i > 0 && unicode.IsUpper(r) {
+			prevLower := unicode.IsLower(rs[i-1]) || unicode.IsDigit(rs[i-1])
+			nextLower := i+1 < len(rs) && unicode.IsLower(rs[i+1])
+
+			// "ghostAdminAPI" becomes GHOST_ADMIN_API, and "APIKey" would
+			// become API_KEY
+			if prevLower || (unicode.IsUpper(rs[i-1]) && nextLower) {
+				sb.WriteRune('_')
+			}
+		}
+
+		sb.WriteRune(unicode.ToUpper(r))
+	}
+
+	return sb.String()
+}
+
+// envVars returns every field of t, recursing into structs, that can be
+// overridden by an environment variable.
+func envVars(t reflect.Type, prefix string, path []string) []envVar {
+	vars := []envVar{}
+
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+
+		p := append(append([]string{}, path...), name)
+		n := prefix + envName(name)
+
+		if f.Type.Kind() == reflect.Struct {
+			vars = append(vars, envVars(f.Type, n+"_", p)...)
+			continue
+		}
+
+		vars = append(vars, envVar{name: n, path: p, str: f.Type.Kind() == reflect.String})
+	}
+
+	return vars
+}
+
+// EnvVars returns the name of every environment variable that overrides a
+// field of the config, in the order of the fields. Each is the field's path
+// of json names in upper snake case, prefixed with [EnvPrefix]; for example,
+// castopodConfig.sqlConnectionString is overridden by
+// GHOST_TO_CASTOPOD_CASTOPOD_CONFIG_SQL_CONNECTION_STRING.
+func EnvVars() []string {
+	names := []string{}
+	for _, v := range envVars(reflect.TypeFor[Config](), EnvPrefix, nil) {
+		names = append(names, v.name)
+	}
+
+	return names
+}
+
+// setPath sets the value at path in the decoded json object m, creating
+// objects as needed. Keys are matched case-insensitively, like encoding/json
+// does when decoding, so that the override replaces the file's value no
+// matter how its key was cased.
+func setPath(m map[string]any, path []string, v any) {
+	var next map[string]any
+
+	for k, val := range m {
+		if strings.EqualFold(k, path[0]) {
+			if obj, ok := val.(map[string]any); ok {
+				next = obj
+			}
+
+			delete(m, k)
+		}
+	}
+
+	if len(path) == 1 {
+		m[path[0]] = v
+		return
+	}
+
+	if next == nil {
+		next = make(map[string]any)
+	}
+
+	m[path[0]] = next
+	setPath(next, path[1:], v)
+}
+
+// applyEnvOverrides returns the config json b with every field that has an
+// environment variable, as found by lookup, replaced by the variable's value.
+// The value of NAME_FILE is read from the file that it names instead, such as
+// a Docker or Kubernetes secret, without its trailing newline. Setting both
+// NAME and NAME_FILE is an error.
+//
+// Values of string fields are used as they are. Values of other fields are
+// parsed as json, such as "true", "5" or {"plan": [1]}, and anything that
+// isn't valid json is used as a string, such as "72h" for a duration.
+func applyEnvOverrides(b []byte, lookup func(string) (string, bool)) ([]byte, error) {
+	var m map[string]any
+
+	errs := []string{}
+
+	for _, v := range envVars(reflect.TypeFor[Config](), EnvPrefix, nil) {
+		value, ok := lookup(v.name)
+		f, fileOK := lookup(v.name + "_FILE")
+
+		if ok && fileOK {
+			errs = append(errs, fmt.Sprintf("only one of %v and %v_FILE can be set", v.name, v.name))
+			continue
+		}
+
+		if fileOK {
+			c, err := os.ReadFile(f)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%v_FILE: %v", v.name, err))
+				continue
+			}
+
+			value, ok = strings.TrimRight(string(c), "\r\n"), true
+		}
+
+		if !ok {
+			continue
+		}
+
+		if m == nil {
+			d := json.NewDecoder(bytes.NewReader(b))
+			d.UseNumber()
+
+			err := d.Decode(&m)
+			if err != nil {
+				return b, fmt.Errorf("failed to unmarshal config: %v", err)
+			}
+
+			if m == nil {
+				m = make(map[string]any)
+			}
+		}
+
+		var val any = value
+		if !v.str && json.Valid([]byte(value)) {
+			val = json.RawMessage(value)
+		}
+
+		setPath(m, v.path, val)
+	}
+
+	if len(errs) > 0 {
+		return b, fmt.Errorf("%v", strings.Join(errs, "; "))
+	}
+
+	if m == nil {
+		return b, nil
+	}
+
+	return json.Marshal(m)
+}
+
+// Redacted returns a copy of the config with its secrets hidden, so that it
+// can be shown or logged: passwords, keys and webhook secrets are replaced
+// with "REDACTED", and the password in each connection string is replaced
+// with "***". Secrets are the string fields with a `redact` struct tag.
+func (c Config) Redacted() Config {
+	redact(reflect.ValueOf(&c).Elem())
+
+	return c
+}
+
+// redact hides the secrets in v, which must be a settable struct.
+func redact(v reflect.Value) {
+	t := v.Type()
+
+	for i := range t.NumField() {
+		f := v.Field(i)
+		if !t.Field(i).IsExported() {
+			continue
+		}
+
+		switch {
+		case f.Kind() == reflect.Struct:
+			redact(f)
+		case f.Kind() != reflect.String || f.String() == "":
+		case t.Field(i).Tag.Get("redact") == "dsn":
+			f.SetString(redactDSN(f.String()))
+		case t.Field(i).Tag.Get("redact") != "":
+			f.SetString("REDACTED")
+		}
+	}
+}
+
+// redactDSN replaces the password in a mysql connection string, such as
+// "user:password@tcp(127.0.0.1:3306)/db", with "***".
+func redactDSN(dsn string) string {
+	i := strings.LastIndex(dsn, "@")
+	if i < 0 {
+		return dsn
+	}
+
+	user, _, ok := strings.Cut(dsn[:i], ":")
+	if !ok {
+		return dsn
+	}
+
+	return user + ":***" + dsn[i:]
+}
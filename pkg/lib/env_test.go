@@ -0,0 +1,215 @@
+package ghosttocastopod_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	ghosttocastopod "github.com/charles-m-knox/ghost-to-castopod/pkg/lib"
+)
+
+// writeTestFile writes s to a file named name in dir and returns its path.
+func writeTestFile(t *testing.T, dir, name, s string) string {
+	t.Helper()
+
+	f := filepath.Join(dir, name)
+
+	err := os.WriteFile(f, []byte(s), 0o600)
+	if err != nil {
+		t.Fatalf("failed to write %v: %v", name, err)
+	}
+
+	return f
+}
+
+// Tests that use t.Setenv can't run in parallel.
+func TestLoadConfigEnvOverrides(t *testing.T) {
+	dir := t.TempDir()
+
+	f := writeTestFile(t, dir, "config.json", `{
+		"SQLConnectionString": "ghost:file@tcp(ghost)/ghost",
+		"castopodConfig": {"sqlConnectionString": "castopod:file@tcp(castopod)/castopod", "createdBy": 2},
+		"plans": {"plan_a": [1, "@show"]},
+		"gracePeriod": "1h"
+	}`)
+	secret := writeTestFile(t, dir, "secret", "castopod:secret@tcp(castopod)/castopod\n")
+
+	t.Setenv("GHOST_TO_CASTOPOD_SQL_CONNECTION_STRING", "ghost:env@tcp(ghost)/ghost")
+	t.Setenv("GHOST_TO_CASTOPOD_CASTOPOD_CONFIG_SQL_CONNECTION_STRING_FILE", secret)
+	t.Setenv("GHOST_TO_CASTOPOD_GRACE_PERIOD", "72h")
+	t.Setenv("GHOST_TO_CASTOPOD_DAEMON_INTERVAL", "5m")
+	t.Setenv("GHOST_TO_CASTOPOD_SAFETY_MAX_SUSPENSIONS", "3")
+	t.Setenv("GHOST_TO_CASTOPOD_TIERS", `{"tier_a": [4]}`)
+
+	c, err := ghosttocastopod.LoadConfig(f)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	if c.SQLConnectionString != "ghost:env@tcp(ghost)/ghost" {
+		t.Errorf("SQLConnectionString mismatch, got %v", c.SQLConnectionString)
+	}
+
+	if c.CastopodConfig.SQLConnectionString != "castopod:secret@tcp(castopod)/castopod" {
+		t.Errorf("CastopodConfig.SQLConnectionString mismatch, got %q", c.CastopodConfig.SQLConnectionString)
+	}
+
+	// fields without an override keep the file's value
+	if c.CastopodConfig.CreatedBy != 2 {
+		t.Errorf("CastopodConfig.CreatedBy mismatch, got %v", c.CastopodConfig.CreatedBy)
+	}
+
+	if time.Duration(c.GracePeriod) != 72*time.Hour {
+		t.Errorf("GracePeriod mismatch, got %v", time.Duration(c.GracePeriod))
+	}
+
+	if time.Duration(c.Daemon.Interval) != 5*time.Minute {
+		t.Errorf("Daemon.Interval mismatch, got %v", time.Duration(c.Daemon.Interval))
+	}
+
+	if c.Safety.MaxSuspensions != 3 {
+		t.Errorf("Safety.MaxSuspensions mismatch, got %v", c.Safety.MaxSuspensions)
+	}
+
+	if !slices.Equal(c.Tiers["tier_a"], []uint{4}) {
+		t.Errorf("Tiers mismatch, got %v", c.Tiers)
+	}
+
+	if !slices.Equal(c.Plans["plan_a"], []uint{1}) || !slices.Equal(c.PodcastHandles(), []string{"@show"}) {
+		t.Errorf("plans mismatch, got %v and handles %v", c.Plans, c.PodcastHandles())
+	}
+}
+
+func TestLoadConfigEnvOverridesErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	f := writeTestFile(t, dir, "config.json", `{}`)
+	secret := writeTestFile(t, dir, "secret", "hunter2")
+
+	tests := []struct {
+		env map[string]string
+		err string
+	}{
+		{
+			map[string]string{
+				"GHOST_TO_CASTOPOD_WEBHOOKS_SECRET":      "hunter2",
+				"GHOST_TO_CASTOPOD_WEBHOOKS_SECRET_FILE": secret,
+			},
+			"only one of GHOST_TO_CASTOPOD_WEBHOOKS_SECRET and GHOST_TO_CASTOPOD_WEBHOOKS_SECRET_FILE can be set",
+		},
+		{
+			map[string]string{"GHOST_TO_CASTOPOD_WEBHOOKS_SECRET_FILE": filepath.Join(dir, "missing")},
+			"GHOST_TO_CASTOPOD_WEBHOOKS_SECRET_FILE",
+		},
+		{
+			map[string]string{"GHOST_TO_CASTOPOD_CASTOPOD_CONFIG_ORPHAN_POLICY": "delete"},
+			"orphanPolicy",
+		},
+		{
+			map[string]string{"GHOST_TO_CASTOPOD_GRACE_PERIOD": "soon"},
+			"soon",
+		},
+	}
+
+	for i, test := range tests {
+		// each subtest's variables are unset when it ends
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			for k, v := range test.env {
+				t.Setenv(k, v)
+			}
+
+			_, err := ghosttocastopod.LoadConfig(f)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("test %v failed: got err %v, want one containing %q", i, err, test.err)
+			}
+		})
+	}
+}
+
+func TestEnvVars(t *testing.T) {
+	t.Parallel()
+
+	names := ghosttocastopod.EnvVars()
+
+	for _, want := range []string{
+		"GHOST_TO_CASTOPOD_SQL_CONNECTION_STRING",
+		"GHOST_TO_CASTOPOD_CASTOPOD_CONFIG_SQL_CONNECTION_STRING",
+		"GHOST_TO_CASTOPOD_CASTOPOD_CONFIG_REDIS_PASSWORD",
+		"GHOST_TO_CASTOPOD_GHOST_ADMIN_API_KEY",
+		"GHOST_TO_CASTOPOD_NOTIFICATIONS_SMTP_PASSWORD",
+		"GHOST_TO_CASTOPOD_DAEMON_INTERVAL",
+		"GHOST_TO_CASTOPOD_PLANS",
+	} {
+		if !slices.Contains(names, want) {
+			t.Errorf("EnvVars is missing %v", want)
+		}
+	}
+
+	sorted := slices.Clone(names)
+	slices.Sort(sorted)
+
+	if len(slices.Compact(sorted)) != len(names) {
+		t.Errorf("EnvVars has duplicates: %v", names)
+	}
+}
+
+func TestConfigRedacted(t *testing.T) {
+	t.Parallel()
+
+	c, err := loadConfigJSON(`{
+		"sqlConnectionString": "ghost:ghostpw@tcp(ghost:3306)/ghost",
+		"castopodConfig": {
+			"sqlConnectionString": "castopod:castopodpw@tcp(castopod:3306)/castopod",
+			"redis": {"addr": "redis:6379", "password": "redispw"},
+			"managedPodcasts": [1, "@show"]
+		},
+		"ghostAdminAPI": {"url": "https://ghost.example.com", "key": "id:apikey"},
+		"webhooks": {"secret": "webhooksecret"},
+		"notifications": {"smtp": {"username": "mailer", "password": "smtppw"}},
+		"plans": {"plan_a": [2, "@show"]}
+	}`)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	b, err := json.Marshal(c.Redacted())
+	if err != nil {
+		t.Fatalf("failed to marshal config: %v", err)
+	}
+
+	s := string(b)
+
+	for _, secret := range []string{"ghostpw", "castopodpw", "redispw", "apikey", "webhooksecret", "smtppw"} {
+		if strings.Contains(s, secret) {
+			t.Errorf("redacted config contains %v: %v", secret, s)
+		}
+	}
+
+	for _, want := range []string{"ghost:***@tcp(ghost:3306)/ghost", "redis:6379", "mailer", `"@show"`, "https://ghost.example.com"} {
+		if !strings.Contains(s, want) {
+			t.Errorf("redacted config is missing %v: %v", want, s)
+		}
+	}
+
+	// redacting a copy leaves the original alone
+	if c.CastopodConfig.Redis.Password != "redispw" {
+		t.Errorf("Redacted modified the config")
+	}
+
+	// the marshaled config can be loaded again, handles included
+	var c2 ghosttocastopod.Config
+
+	err = json.Unmarshal(b, &c2)
+	if err != nil {
+		t.Fatalf("failed to unmarshal redacted config: %v", err)
+	}
+
+	if !slices.Equal(c2.PodcastHandles(), []string{"@show"}) || !slices.Equal(c2.Plans["plan_a"], []uint{2}) {
+		t.Errorf("round trip mismatch, got plans %v and handles %v", c2.Plans, c2.PodcastHandles())
+	}
+}
@@ -0,0 +1,119 @@
+package ghosttocastopod_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// fakeDB is a minimal database/sql driver used by the unit tests. It records
+// every executed statement and answers queries from a user-provided table of
+// canned rows, keyed by a substring of the query.
+type fakeDB struct {
+	mu sync.Mutex
+
+	// execs is every statement executed through the driver, in order.
+	execs []fakeExec
+	// rows maps a query substring to the rows that should be returned.
+	rows map[string]*fakeRows
+	// failExec, if set, is consulted before each exec and can return an error.
+	failExec func(query string, args []driver.Value) error
+
+	commits   int
+	rollbacks int
+}
+
+type fakeExec struct {
+	query string
+	args  []driver.Value
+}
+
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+	i       int
+}
+
+// open returns a *sql.DB that is backed by f.
+func (f *fakeDB) open() *sql.DB {
+	return sql.OpenDB(f)
+}
+
+func (f *fakeDB) Connect(context.Context) (driver.Conn, error) { return &fakeConn{db: f}, nil }
+func (f *fakeDB) Driver() driver.Driver                        { return nil }
+
+type fakeConn struct{ db *fakeDB }
+
+func (c *fakeConn) Prepare(q string) (driver.Stmt, error) { return &fakeStmt{db: c.db, query: q}, nil }
+func (c *fakeConn) Close() error                          { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error)             { return &fakeTx{db: c.db}, nil }
+
+type fakeTx struct{ db *fakeDB }
+
+func (t *fakeTx) Commit() error {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+	t.db.commits++
+	return nil
+}
+
+func (t *fakeTx) Rollback() error {
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+	t.db.rollbacks++
+	return nil
+}
+
+type fakeStmt struct {
+	db    *fakeDB
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if s.db.failExec != nil {
+		if err := s.db.failExec(s.query, args); err != nil {
+			return nil, err
+		}
+	}
+
+	s.db.execs = append(s.db.execs, fakeExec{query: s.query, args: args})
+
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	for k, r := range s.db.rows {
+		if strings.Contains(s.query, k) {
+			return &fakeRows{columns: r.columns, values: r.values}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("fakedb: no rows configured for query %q", s.query)
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.i >= len(r.values) {
+		return io.EOF
+	}
+
+	copy(dest, r.values[r.i])
+	r.i++
+
+	return nil
+}
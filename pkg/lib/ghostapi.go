@@ -0,0 +1,232 @@
+package ghosttocastopod
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Connection details for the Ghost Admin API.
+type GhostAdminAPIConfig struct {
+	// The base URL of the Ghost site, such as https://example.com. Do not
+	// include the /ghost/api/admin path.
+	URL string `json:"url"`
+	// An Admin API key belonging to a Ghost custom integration, in the form
+	// "id:secret".
+	Key string `json:"key" redact:"true"`
+}
+
+// The number of members to request per page from the Ghost Admin API.
+const GhostAdminAPIPageLimit = 100
+
+// ghostAdminAPIMembersPath is appended to [GhostAdminAPIConfig.URL].
+const ghostAdminAPIMembersPath = "/ghost/api/admin/members/"
+
+// ghostAdminAPITokenLifetime is how long each signed token remains valid. Ghost
+// rejects tokens that expire more than 5 minutes in the future.
+const ghostAdminAPITokenLifetime = 5 * time.Minute
+
+// GhostAdminAPIToken signs a short-lived JWT for the Ghost Admin API using key,
+// which must be an Admin API key in the form "id:secret". The secret portion is
+// hex-encoded, as provided by Ghost.
+func GhostAdminAPIToken(key string, now time.Time) (string, error) {
+	id, secret, ok := strings.Cut(key, ":")
+	if !ok || id == "" || secret == "" {
+		return "", fmt.Errorf("admin api key must be in the form id:secret")
+	}
+
+	s, err := hex.DecodeString(secret)
+	if err != nil {
+		return "", fmt.Errorf("admin api key secret is not valid hex: %v", err)
+	}
+
+	header, err := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT", "kid": id})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal jwt header: %v", err)
+	}
+
+	payload, err := json.Marshal(map[string]any{
+		"iat": now.Unix(),
+		"exp": now.Add(ghostAdminAPITokenLifetime).Unix(),
+		"aud": "/admin/",
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal jwt payload: %v", err)
+	}
+
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(payload)
+
+	mac := hmac.New(sha256.New, s)
+	mac.Write([]byte(unsigned))
+
+	return unsigned + "." + enc.EncodeToString(mac.Sum(nil)), nil
+}
+
+// ghostAPIMember is the subset of a member object from the Ghost Admin API
+// that this library needs.
+type ghostAPIMember struct {
+	Email         string                 `json:"email"`
+	Status        string                 `json:"status"`
+	Subscriptions []ghostAPISubscription `json:"subscriptions"`
+	// Tiers correspond to rows in the members_products table.
+	Tiers []struct {
+		ID string `json:"id"`
+	} `json:"tiers"`
+}
+
+type ghostAPISubscription struct {
+	Status            string    `json:"status"`
+	CurrentPeriodEnd  time.Time `json:"current_period_end"`
+	CancelAtPeriodEnd bool      `json:"cancel_at_period_end"`
+	// The plan ID corresponds to members_stripe_customers_subscriptions.plan_id
+	Plan struct {
+		ID string `json:"id"`
+	} `json:"plan"`
+}
+
+// memberships converts a member from the Admin API or a webhook into a list of
+// Ghost memberships: one per Stripe subscription, followed by one per tier. If
+// status is not empty, it overrides every membership's status.
+//
+// Subscriptions without a plan ID are skipped. Ghost 5 lists a synthetic
+// "Complimentary" subscription with an empty plan for comped members, who are
+// already covered by their tier membership.
+func (m ghostAPIMember) memberships(status string) []GhostMembership {
+	gms := []GhostMembership{}
+
+	for _, s := range m.Subscriptions {
+		if s.Plan.ID == "" {
+			continue
+		}
+
+		gms = append(gms, GhostMembership{
+			Email:             m.Email,
+			Status:            s.Status,
+			PlanID:            s.Plan.ID,
+			CurrentPeriodEnd:  s.CurrentPeriodEnd,
+			CancelAtPeriodEnd: s.CancelAtPeriodEnd,
+		})
+	}
+
+	for _, t := range m.Tiers {
+		gms = append(gms, GhostMembership{Email: m.Email, Status: m.Status, TierID: t.ID})
+	}
+
+	if status != "" {
+		for i := range gms {
+			gms[i].Status = status
+		}
+	}
+
+	return gms
+}
+
+type ghostAPIMembersResponse struct {
+	Members []ghostAPIMember `json:"members"`
+	Meta    struct {
+		Pagination struct {
+			Page  int  `json:"page"`
+			Pages int  `json:"pages"`
+			Next  *int `json:"next"`
+		} `json:"pagination"`
+	} `json:"meta"`
+}
+
+// GetGhostMembershipsFromAdminAPI pages through every member in the Ghost Admin
+// API and returns one [GhostMembership] per Stripe subscription and per tier,
+// exactly like reading [GHOST_MEMBERSHIP_QUERY] and [GHOST_TIER_MEMBERSHIP_QUERY]
+// through [Config.GetGhostMembership] and [Config.GetGhostTierMembership] would.
+// If client is nil, [http.DefaultClient] is used.
+func (c *Config) GetGhostMembershipsFromAdminAPI(ctx context.Context, client *http.Client) ([]GhostMembership, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	u, err := url.Parse(strings.TrimSuffix(c.GhostAdminAPI.URL, "/") + ghostAdminAPIMembersPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ghost admin api url: %v", err)
+	}
+
+	gms := []GhostMembership{}
+
+	for page := 1; ; page++ {
+		resp, err := c.getGhostAdminAPIMembersPage(ctx, client, *u, page)
+		if err != nil {
+			return gms, err
+		}
+
+		for _, m := range resp.Members {
+			for _, gm := range m.memberships("") {
+				gm, err := c.ProcessGhostMembership(gm)
+				if err != nil {
+					return gms, fmt.Errorf("invalid membership on page %v: %v", page, err)
+				}
+
+				gms = append(gms, gm)
+			}
+		}
+
+		if resp.Meta.Pagination.Next == nil || page >= resp.Meta.Pagination.Pages {
+			break
+		}
+	}
+
+	return gms, nil
+}
+
+// getGhostAdminAPIMembersPage retrieves a single page of members from the
+// Ghost Admin API.
+func (c *Config) getGhostAdminAPIMembersPage(ctx context.Context, client *http.Client, u url.URL, page int) (ghostAPIMembersResponse, error) {
+	var r ghostAPIMembersResponse
+
+	q := u.Query()
+	q.Set("limit", fmt.Sprint(GhostAdminAPIPageLimit))
+	q.Set("page", fmt.Sprint(page))
+	u.RawQuery = q.Encode()
+
+	token, err := GhostAdminAPIToken(c.GhostAdminAPI.Key, time.Now())
+	if err != nil {
+		return r, fmt.Errorf("failed to sign ghost admin api token: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return r, fmt.Errorf("failed to build ghost admin api request: %v", err)
+	}
+
+	req.Header.Set("Authorization", "Ghost "+token)
+	req.Header.Set("Accept-Version", "v5.0")
+
+	res, err := client.Do(req)
+	if err != nil {
+		return r, fmt.Errorf("failed to request page %v of ghost members: %v", page, err)
+	}
+
+	defer res.Body.Close()
+
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return r, fmt.Errorf("failed to read page %v of ghost members: %v", page, err)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return r, fmt.Errorf("ghost admin api returned %v for page %v: %v", res.Status, page, string(b))
+	}
+
+	err = json.Unmarshal(b, &r)
+	if err != nil {
+		return r, fmt.Errorf("failed to unmarshal page %v of ghost members: %v", page, err)
+	}
+
+	return r, nil
+}
@@ -0,0 +1,160 @@
+package ghosttocastopod_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	ghosttocastopod "github.com/charles-m-knox/ghost-to-castopod/pkg/lib"
+)
+
+const testAdminAPIKey = "66c3f38aedcb1c0101f6ee4d:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+// verifyGhostAdminToken checks that the token was signed with
+// testAdminAPIKey and is intended for the admin api.
+func verifyGhostAdminToken(token string) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("token has %v parts", len(parts))
+	}
+
+	_, hexSecret, _ := strings.Cut(testAdminAPIKey, ":")
+	secret, err := hex.DecodeString(hexSecret)
+	if err != nil {
+		return err
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if base64.RawURLEncoding.EncodeToString(mac.Sum(nil)) != parts[2] {
+		return fmt.Errorf("signature mismatch")
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return err
+	}
+
+	var payload struct {
+		Aud string `json:"aud"`
+		Iat int64  `json:"iat"`
+		Exp int64  `json:"exp"`
+	}
+	err = json.Unmarshal(b, &payload)
+	if err != nil {
+		return err
+	}
+
+	if payload.Aud != "/admin/" || payload.Exp-payload.Iat != 300 {
+		return fmt.Errorf("unexpected payload: %+v", payload)
+	}
+
+	return nil
+}
+
+func TestGhostAdminAPIToken(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		key string
+		err bool
+	}{
+		{testAdminAPIKey, false},
+		{"", true},
+		{"nosecret", true},
+		{"id:not-hex", true},
+	}
+
+	for i, test := range tests {
+		token, err := ghosttocastopod.GhostAdminAPIToken(test.key, time.Now())
+		if err != nil && !test.err {
+			t.Errorf("test %v failed: received unexpected err: %v", i, err)
+		} else if err == nil && test.err {
+			t.Errorf("test %v failed: did not receive error but wanted one", i)
+		} else if err == nil {
+			if err := verifyGhostAdminToken(token); err != nil {
+				t.Errorf("test %v failed: invalid token: %v", i, err)
+			}
+		}
+	}
+}
+
+func TestGetGhostMembershipsFromAdminAPI(t *testing.T) {
+	t.Parallel()
+
+	pages := []string{
+		`{"members":[
+			{"email":"foo@example.com","subscriptions":[{"status":"active","plan":{"id":"plan1"}},{"status":"canceled","plan":{"id":"plan2"}}]},
+			{"email":"free@example.com","status":"free","subscriptions":[],"tiers":[]},
+			{"email":"comped@example.com","status":"comped","subscriptions":[{"id":"","status":"active","plan":{"id":"","nickname":"Complimentary"}}],"tiers":[{"id":"tier1"}]}
+		],"meta":{"pagination":{"page":1,"limit":100,"pages":2,"total":3,"next":2,"prev":null}}}`,
+		`{"members":[
+			{"email":"bar@example.com","subscriptions":[{"status":"active","plan":{"id":"plan2"},"current_period_end":"2024-09-01T00:00:00.000Z","cancel_at_period_end":true}]}
+		],"meta":{"pagination":{"page":2,"limit":100,"pages":2,"total":3,"next":null,"prev":1}}}`,
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ghost/api/admin/members/" {
+			http.NotFound(w, r)
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Ghost ")
+		if !ok || verifyGhostAdminToken(token) != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var page int
+		fmt.Sscan(r.URL.Query().Get("page"), &page)
+		if page < 1 || page > len(pages) {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, pages[page-1])
+	}))
+	defer srv.Close()
+
+	c := ghosttocastopod.Config{
+		GhostAdminAPI: ghosttocastopod.GhostAdminAPIConfig{URL: srv.URL + "/", Key: testAdminAPIKey},
+	}
+
+	got, err := c.GetGhostMembershipsFromAdminAPI(context.Background(), srv.Client())
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	want := []ghosttocastopod.GhostMembership{
+		{Email: "foo@example.com", Status: gActive, PlanID: "plan1"},
+		{Email: "foo@example.com", Status: "canceled", PlanID: "plan2"},
+		{Email: "comped@example.com", Status: ghosttocastopod.GhostMemberStatusComped, TierID: "tier1"},
+		{Email: "bar@example.com", Status: gActive, PlanID: "plan2", CurrentPeriodEnd: time.Date(2024, 9, 1, 0, 0, 0, 0, time.UTC), CancelAtPeriodEnd: true},
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("result length mismatch, got %v, want %v", got, want)
+	}
+
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("membership %v mismatch, got %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	c.GhostAdminAPI.Key = "bad:0000"
+	_, err = c.GetGhostMembershipsFromAdminAPI(context.Background(), srv.Client())
+	if err == nil {
+		t.Errorf("expected an error for an unauthorized key but did not receive one")
+	}
+}
@@ -3,7 +3,9 @@ package ghosttocastopod
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"os"
 	"slices"
 	"time"
@@ -22,18 +24,52 @@ type CastopodConfig struct {
 	// should be 1 - the admin account.
 	UpdatedBy uint `json:"updatedBy"`
 	// Connection string for the Castopod mysql database.
-	SQLConnectionString string `json:"sqlConnectionString"`
+	SQLConnectionString string `json:"sqlConnectionString" redact:"dsn"`
+	// Determines what happens to Castopod subscriptions whose email address
+	// has no Ghost membership at all and is not a blessed account, such as
+	// members that were deleted from Ghost. Can be "suspend" (the default) or
+	// "flag", which leaves the subscription untouched but sets its Orphaned
+	// field so that it can be reported.
+	OrphanPolicy string `json:"orphanPolicy"`
+	// The podcast IDs whose subscriptions are managed by this application.
+	// Subscriptions to a managed podcast are suspended when they are no longer
+	// granted by any plan, tier or blessed account, or when they are orphaned.
+	// Subscriptions to any other podcast are never suspended for those reasons.
+	// If left empty, every podcast is managed.
+	ManagedPodcasts []uint `json:"managedPodcasts"`
+	// Optional; if Redis.Addr is set, Castopod's cache is invalidated for
+	// every podcast whose subscriptions were written.
+	Redis RedisConfig `json:"redis"`
+	// The advisory lock that is held on the Castopod database around each
+	// sync. See [Config.AcquireRunLock].
+	Lock LockConfig `json:"lock"`
+
+	// podcasts in ManagedPodcasts that were given by handle
+	managedHandles []string
 }
 
+const (
+	OrphanPolicySuspend = "suspend"
+	OrphanPolicyFlag    = "flag"
+)
+
 type Config struct {
 	// Connection string for the Ghost mysql database.
-	SQLConnectionString string `json:"sqlConnectionString"`
+	SQLConnectionString string `json:"sqlConnectionString" redact:"dsn"`
 
 	// Represents a mapping of plan IDs to Castopod podcast IDs. For example,
 	// the plan with ID 66c3f38aedcb1c0101f6ee4d should grant you access to
-	// podcast IDs 1,2,4, etc.
+	// podcast IDs 1,2,4, etc. In JSON, podcasts can also be given by their
+	// Castopod handle, such as "@mypodcast", in Plans, Tiers, BlessedAccounts
+	// and [CastopodConfig.ManagedPodcasts]; see [Config.ResolvePodcastHandles].
 	Plans map[string][]uint `json:"plans"`
 
+	// Represents a mapping of Ghost tier (product) IDs to Castopod podcast IDs.
+	// Unlike Plans, tiers also cover members that were given a tier manually
+	// in Ghost admin, such as comped members, who have no Stripe
+	// subscription. See [GHOST_TIER_MEMBERSHIP_QUERY].
+	Tiers map[string][]uint `json:"tiers"`
+
 	// Represents a mapping of emails to Castopod podcast IDs. For example, the
 	// account webmaster@example.com should grant you access to podcast IDs
 	// 1,2,3,4, etc. These accounts are "blessed" because they will exist in
@@ -41,26 +77,185 @@ type Config struct {
 	BlessedAccounts map[string][]uint `json:"blessedAccounts"`
 
 	CastopodConfig CastopodConfig `json:"castopodConfig"`
+
+	// Optional connection details for the Ghost Admin API, which can be used
+	// in place of SQLConnectionString when the Ghost database is not directly
+	// accessible. See [Config.GetGhostMembershipsFromAdminAPI].
+	GhostAdminAPI GhostAdminAPIConfig `json:"ghostAdminAPI"`
+
+	// Configuration for receiving Ghost member webhooks. See
+	// [Config.NewWebhookHandler].
+	Webhooks WebhookConfig `json:"webhooks"`
+
+	// Maps Ghost membership statuses to what they mean for Castopod access:
+	// "grant", "suspend" or "grace". This covers both Stripe subscription
+	// statuses (active, trialing, past_due, unpaid, canceled, incomplete, ...)
+	// for plans and member statuses (paid, comped, free) for tiers. Statuses
+	// that are not listed fall back to [DefaultStatusPolicies], and anything
+	// unknown is suspended.
+	StatusPolicies map[string]string `json:"statusPolicies"`
+
+	// How long a membership whose status has the "grace" policy, such as
+	// past_due, keeps access. It is measured from the last time Ghost updated
+	// the subscription, which is when Stripe reported the status change. For
+	// example, "72h". Memberships from the Ghost Admin API or webhooks do not
+	// include that timestamp, so they receive no grace period.
+	GracePeriod Duration `json:"gracePeriod"`
+
+	// Optional; emails new and reactivated subscribers a link to their
+	// private feed. See [Config.SendNotifications].
+	Notifications NotificationConfig `json:"notifications"`
+
+	// Configuration for running the sync repeatedly from a long-running
+	// process instead of from cron.
+	Daemon DaemonConfig `json:"daemon"`
+
+	// Optional; exposes Prometheus metrics and a health check over HTTP. See
+	// [Metrics].
+	Metrics MetricsConfig `json:"metrics"`
+
+	// Optional; records every subscription change that is written to
+	// Castopod in a JSONL file. See [Config.WriteAuditLog].
+	Audit AuditConfig `json:"audit"`
+
+	// Limits on how many subscriptions a single sync may suspend. See
+	// [Config.CheckSuspensions].
+	Safety SafetyConfig `json:"safety"`
+
+	// Configures the logger created by [LogConfig.NewLogger]. By default,
+	// email addresses and tokens are hashed before they are logged.
+	Log LogConfig `json:"log"`
+
+	// The logger used by the library. If nil, [slog.Default] is used, with
+	// email addresses and tokens redacted according to Log.
+	Logger *slog.Logger `json:"-"`
+
+	// podcasts that were given by handle; see [Config.UnmarshalJSON]
+	handles podcastHandles
+}
+
+// DaemonConfig determines how often a long-running process syncs.
+type DaemonConfig struct {
+	// How long to wait after a sync finishes before starting the next one.
+	// Defaults to [DefaultDaemonInterval].
+	Interval Duration `json:"interval"`
+	// Up to this much additional time, chosen at random, is added to each
+	// interval so that several hosts don't sync in lockstep.
+	Jitter Duration `json:"jitter"`
+	// When asked to stop, how long to wait for an in-flight sync to finish
+	// before canceling it, which rolls back its write. Defaults to
+	// [DefaultDaemonShutdownTimeout].
+	ShutdownTimeout Duration `json:"shutdownTimeout"`
+}
+
+const (
+	DefaultDaemonInterval        = 15 * time.Minute
+	DefaultDaemonShutdownTimeout = 30 * time.Second
+)
+
+// Duration is a [time.Duration] that is represented in JSON as a string, such
+// as "72h" or "30m".
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return fmt.Errorf("duration must be a string such as \"72h\": %v", err)
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q: %v", s, err)
+	}
+
+	*d = Duration(v)
+
+	return nil
+}
+
+const (
+	// The membership grants access.
+	StatusPolicyGrant = "grant"
+	// The membership does not grant access.
+	StatusPolicySuspend = "suspend"
+	// The membership grants access for [Config.GracePeriod] after Ghost last
+	// updated it, and is suspended afterwards.
+	StatusPolicyGrace = "grace"
+)
+
+// DefaultStatusPolicies is used for any status that isn't configured in
+// [Config.StatusPolicies]. Only past_due has a grace period, which is zero
+// unless [Config.GracePeriod] is set.
+var DefaultStatusPolicies = map[string]string{
+	"active":             StatusPolicyGrant,
+	"trialing":           StatusPolicyGrant,
+	"past_due":           StatusPolicyGrace,
+	"unpaid":             StatusPolicySuspend,
+	"canceled":           StatusPolicySuspend,
+	"incomplete":         StatusPolicySuspend,
+	"incomplete_expired": StatusPolicySuspend,
+	"paused":             StatusPolicySuspend,
+	"paid":               StatusPolicyGrant,
+	"comped":             StatusPolicyGrant,
+	"free":               StatusPolicySuspend,
 }
 
 const GHOST_MEMBERSHIP_QUERY = `SELECT
   m.email as email,
   mscs.status,
-  mscs.plan_id as plan_id
+  mscs.plan_id as plan_id,
+  mscs.current_period_end as current_period_end,
+  mscs.cancel_at_period_end as cancel_at_period_end,
+  mscs.updated_at as updated_at
 FROM members_stripe_customers as msc
 INNER JOIN members_stripe_customers_subscriptions as mscs
 INNER JOIN members as m
 ON msc.customer_id = mscs.customer_id AND m.id = msc.member_id
 `
 
-const CASTOPOD_SUBSCRIPTION_QUERY = "SELECT id, podcast_id, email, token, status, created_by, updated_by, created_at, updated_at FROM cp_subscriptions"
+// GHOST_TIER_MEMBERSHIP_QUERY lists every member that has been assigned a
+// tier, along with the member's status (free, paid or comped). It is scanned
+// with [Config.GetGhostTierMembership].
+const GHOST_TIER_MEMBERSHIP_QUERY = `SELECT
+  m.email as email,
+  m.status as status,
+  mp.product_id as product_id
+FROM members as m
+INNER JOIN members_products as mp
+ON m.id = mp.member_id
+`
+
+const CASTOPOD_SUBSCRIPTION_QUERY = "SELECT id, podcast_id, email, token, status, expires_at, created_by, updated_by, created_at, updated_at FROM cp_subscriptions"
+
+// CASTOPOD_SUBSCRIPTION_BY_EMAIL_QUERY is [CASTOPOD_SUBSCRIPTION_QUERY] limited
+// to a single email address, which is passed as the only argument.
+const CASTOPOD_SUBSCRIPTION_BY_EMAIL_QUERY = CASTOPOD_SUBSCRIPTION_QUERY + " WHERE email = ?"
 
-// GhostMembership is a struct built upon [GHOST_MEMBERSHIP_QUERY].
+// GhostMembership is a struct built upon [GHOST_MEMBERSHIP_QUERY] or
+// [GHOST_TIER_MEMBERSHIP_QUERY]. Exactly one of PlanID or TierID is set.
 // Currently, none of its fields can be nullable.
 type GhostMembership struct {
-	Email  string
+	Email string
+	// For plan memberships, this is the Stripe subscription status, such as
+	// active or canceled. For tier memberships, this is the member's status,
+	// such as paid or comped.
 	Status string
 	PlanID string
+	TierID string
+	// The end of the current billing period. Only set for plan memberships.
+	CurrentPeriodEnd time.Time
+	// True if the Stripe subscription has been canceled, but remains paid for
+	// until CurrentPeriodEnd. Only set for plan memberships.
+	CancelAtPeriodEnd bool
+	// When Ghost last updated the subscription. Used for [Config.GracePeriod].
+	// Only set for plan memberships read from the database.
+	UpdatedAt time.Time
 }
 
 // CastopodSubscription is a struct that (mostly) mirrors the SQL database's
@@ -71,6 +266,7 @@ type CastopodSubscription struct {
 	Email     string    // 255 chars max
 	Token     string    // sha256 hash of 8 random alphanumeric characters
 	Status    string    // can only be active or suspended
+	ExpiresAt time.Time // nullable; the zero value is written as NULL
 	CreatedBy uint      // defined in the user-provided config
 	UpdatedBy uint      // defined in the user-provided config
 	CreatedAt time.Time // non-null
@@ -79,6 +275,33 @@ type CastopodSubscription struct {
 	// This will get set to true if we changed it from its original database
 	// state. It is not a part of the database.
 	Changed bool
+
+	// Describes which Ghost membership or blessed account decided this
+	// subscription's status, such as "plan foo is active". It is not a part of
+	// the database.
+	Reason string
+
+	// This will get set to true if the email address has no Ghost membership
+	// and is not a blessed account. See [CastopodConfig.OrphanPolicy]. It is
+	// not a part of the database.
+	Orphaned bool
+
+	// The plaintext token whose hash is Token. It is only set when the token
+	// was generated during this run, such as for newly created subscriptions,
+	// since Castopod only stores the hash. Use it to build the subscriber's
+	// private feed URL; it is never written to Castopod.
+	RawToken string `json:"-"`
+
+	// The status that was read from Castopod, or empty if this subscription
+	// is being created. It is set by [Config.GetSyncPlan] and is not a part of
+	// the database.
+	OldStatus string
+
+	// The Ghost membership that decided this subscription's status, if any.
+	// It is the zero value for blessed accounts and for subscriptions that
+	// were suspended because nothing grants them. It is not a part of the
+	// database.
+	Membership GhostMembership
 }
 
 func (c *Config) ProcessGhostMembership(m GhostMembership) (GhostMembership, error) {
@@ -88,8 +311,8 @@ func (c *Config) ProcessGhostMembership(m GhostMembership) (GhostMembership, err
 	if m.Status == "" {
 		return m, fmt.Errorf("Status cannot be empty")
 	}
-	if m.PlanID == "" {
-		return m, fmt.Errorf("PlanID cannot be empty")
+	if m.PlanID == "" && m.TierID == "" {
+		return m, fmt.Errorf("PlanID and TierID cannot both be empty")
 	}
 
 	return m, nil
@@ -97,15 +320,90 @@ func (c *Config) ProcessGhostMembership(m GhostMembership) (GhostMembership, err
 
 func (c *Config) GetGhostMembership(rows *sql.Rows) (GhostMembership, error) {
 	var m GhostMembership
+	var currentPeriodEnd, updatedAt sql.NullString
 
-	err := rows.Scan(&m.Email, &m.Status, &m.PlanID)
+	err := rows.Scan(&m.Email, &m.Status, &m.PlanID, &currentPeriodEnd, &m.CancelAtPeriodEnd, &updatedAt)
 	if err != nil {
 		return m, fmt.Errorf("failed to marshal row into interface: %v", err.Error())
 	}
 
+	if updatedAt.Valid {
+		m.UpdatedAt, err = parseDatabaseTime(updatedAt.String)
+		if err != nil {
+			return m, fmt.Errorf("failed to parse UpdatedAt datetime: %v", err.Error())
+		}
+	}
+
+	if currentPeriodEnd.Valid {
+		m.CurrentPeriodEnd, err = parseDatabaseTime(currentPeriodEnd.String)
+		if err != nil {
+			return m, fmt.Errorf("failed to parse CurrentPeriodEnd datetime: %v", err.Error())
+		}
+	}
+
 	return c.ProcessGhostMembership(m)
 }
 
+// parseDatabaseTime parses a datetime column that was scanned into a string.
+// Most drivers produce [CastopodTimeFormat], but the mysql driver produces
+// RFC 3339 when it is configured with parseTime=true.
+func parseDatabaseTime(s string) (time.Time, error) {
+	t, err := time.Parse(CastopodTimeFormat, s)
+	if err == nil {
+		return t, nil
+	}
+
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+// GetGhostTierMembership scans a single row produced by
+// [GHOST_TIER_MEMBERSHIP_QUERY].
+func (c *Config) GetGhostTierMembership(rows *sql.Rows) (GhostMembership, error) {
+	var m GhostMembership
+
+	err := rows.Scan(&m.Email, &m.Status, &m.TierID)
+	if err != nil {
+		return m, fmt.Errorf("failed to marshal row into interface: %v", err.Error())
+	}
+
+	return c.ProcessGhostMembership(m)
+}
+
+// GetCastopodSubscription scans a single row produced by
+// [CASTOPOD_SUBSCRIPTION_QUERY] or [CASTOPOD_SUBSCRIPTION_BY_EMAIL_QUERY].
+func (c *Config) GetCastopodSubscription(rows *sql.Rows) (CastopodSubscription, error) {
+	var s CastopodSubscription
+	var expiresAt, createdAt, updatedAt sql.NullString
+
+	err := rows.Scan(&s.ID, &s.PodcastID, &s.Email, &s.Token, &s.Status, &expiresAt, &s.CreatedBy, &s.UpdatedBy, &createdAt, &updatedAt)
+	if err != nil {
+		return s, fmt.Errorf("failed to scan row: %v", err.Error())
+	}
+
+	if expiresAt.Valid {
+		s.ExpiresAt, err = parseDatabaseTime(expiresAt.String)
+		if err != nil {
+			return s, fmt.Errorf("failed to parse ExpiresAt datetime: %v", err.Error())
+		}
+	}
+
+	if createdAt.Valid {
+		s.CreatedAt, err = parseDatabaseTime(createdAt.String)
+		if err != nil {
+			return s, fmt.Errorf("failed to parse CreatedAt datetime: %v", err.Error())
+		}
+	}
+
+	if updatedAt.Valid {
+		s.UpdatedAt, err = parseDatabaseTime(updatedAt.String)
+		if err != nil {
+			return s, fmt.Errorf("failed to parse UpdatedAt datetime: %v", err.Error())
+		}
+	}
+
+	return s, nil
+}
+
 // ApplyDefaults applies sensible defaults to the config if left unconfigured.
 // You shouldn't normally need to execute this, because it's called
 // automatically by [LoadConfig].
@@ -116,6 +414,10 @@ func (c *Config) ApplyDefaults() {
 		c.Plans = make(map[string][]uint)
 	}
 
+	if len(c.Tiers) == 0 {
+		c.Tiers = make(map[string][]uint)
+	}
+
 	if len(c.BlessedAccounts) == 0 {
 		c.BlessedAccounts = make(map[string][]uint)
 	}
@@ -128,10 +430,49 @@ func (c *Config) ApplyDefaults() {
 		c.CastopodConfig.UpdatedBy = 1
 	}
 
+	if c.CastopodConfig.OrphanPolicy == "" {
+		c.CastopodConfig.OrphanPolicy = OrphanPolicySuspend
+	}
+
+	if c.CastopodConfig.Lock.Name == "" {
+		c.CastopodConfig.Lock.Name = DefaultLockName
+	}
+
+	if c.Webhooks.LockTimeout == 0 {
+		c.Webhooks.LockTimeout = Duration(DefaultWebhookLockTimeout)
+	}
+
+	if c.Log.Redact == "" {
+		c.Log.Redact = RedactHash
+	}
+
+	if c.Daemon.Interval == 0 {
+		c.Daemon.Interval = Duration(DefaultDaemonInterval)
+	}
+
+	if c.Daemon.ShutdownTimeout == 0 {
+		c.Daemon.ShutdownTimeout = Duration(DefaultDaemonShutdownTimeout)
+	}
+
+	if len(c.StatusPolicies) == 0 {
+		c.StatusPolicies = make(map[string]string)
+	}
+
+	for status, policy := range DefaultStatusPolicies {
+		_, ok := c.StatusPolicies[status]
+		if !ok {
+			c.StatusPolicies[status] = policy
+		}
+	}
+
 	for i := range c.Plans {
 		slices.Sort(c.Plans[i])
 	}
 
+	for i := range c.Tiers {
+		slices.Sort(c.Tiers[i])
+	}
+
 	for k := range c.BlessedAccounts {
 		slices.Sort(c.BlessedAccounts[k])
 	}
@@ -139,12 +480,24 @@ func (c *Config) ApplyDefaults() {
 
 // LoadConfig reads from file f and applies sensible defaults to values not
 // specifically set by the user.
+//
+// Every field can be overridden by an environment variable, such as
+// GHOST_TO_CASTOPOD_SQL_CONNECTION_STRING for sqlConnectionString, or by a
+// file named by the same variable with a _FILE suffix, such as a Docker or
+// Kubernetes secret; see [EnvVars] for the names. The precedence, from
+// highest to lowest, is the environment variable or its _FILE variant (which
+// can't both be set), then the file f, then the defaults.
 func LoadConfig(f string) (Config, error) {
 	b, err := os.ReadFile(f)
 	if err != nil {
 		return Config{}, fmt.Errorf("failed to load config from %v: %v", f, err)
 	}
 
+	b, err = applyEnvOverrides(b, os.LookupEnv)
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to override config from %v with environment variables: %v", f, err)
+	}
+
 	var c Config
 	err = json.Unmarshal(b, &c)
 	if err != nil {
@@ -153,18 +506,290 @@ func LoadConfig(f string) (Config, error) {
 
 	c.ApplyDefaults()
 
+	err = c.Validate()
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid config in %v: %v", f, err)
+	}
+
 	return c, nil
 }
 
+// Validate checks the config for values that can't be correct regardless of
+// the state of Ghost or Castopod. It is called automatically by [LoadConfig].
+// See [Config.ValidateAgainstDatabases] for the checks that need them.
+func (c *Config) Validate() error {
+	errs := []error{}
+
+	for status, policy := range c.StatusPolicies {
+		switch policy {
+		case StatusPolicyGrant, StatusPolicySuspend, StatusPolicyGrace:
+		default:
+			errs = append(errs, fmt.Errorf("statusPolicies: %v has unknown policy %q", status, policy))
+		}
+	}
+
+	switch c.CastopodConfig.OrphanPolicy {
+	case "", OrphanPolicySuspend, OrphanPolicyFlag:
+	default:
+		errs = append(errs, fmt.Errorf("castopodConfig.orphanPolicy: unknown policy %q", c.CastopodConfig.OrphanPolicy))
+	}
+
+	if c.GracePeriod < 0 {
+		errs = append(errs, fmt.Errorf("gracePeriod cannot be negative"))
+	}
+
+	if c.CastopodConfig.Lock.Timeout < 0 {
+		errs = append(errs, fmt.Errorf("castopodConfig.lock.timeout cannot be negative"))
+	}
+
+	if c.Webhooks.Listen != "" && c.Webhooks.Secret == "" {
+		errs = append(errs, fmt.Errorf("webhooks.secret is required when webhooks.listen is set"))
+	}
+
+	if c.Webhooks.LockTimeout < 0 {
+		errs = append(errs, fmt.Errorf("webhooks.lockTimeout cannot be negative"))
+	}
+
+	if len(c.CastopodConfig.Lock.Name) > 64 {
+		errs = append(errs, fmt.Errorf("castopodConfig.lock.name cannot be longer than 64 characters"))
+	}
+
+	if c.Daemon.Interval < 0 || c.Daemon.Jitter < 0 || c.Daemon.ShutdownTimeout < 0 {
+		errs = append(errs, fmt.Errorf("daemon: durations cannot be negative"))
+	}
+
+	if c.Metrics.MaxAge < 0 {
+		errs = append(errs, fmt.Errorf("metrics.maxAge cannot be negative"))
+	}
+
+	errs = append(errs, c.Notifications.validate()...)
+	errs = append(errs, c.Safety.validate()...)
+	errs = append(errs, c.Log.validate()...)
+
+	return errors.Join(errs...)
+}
+
 const (
 	CastopodStatusSuspended = "suspended"
 	CastopodStatusActive    = "active"
 	GhostStatusActive       = "active"
+	GhostStatusCanceled     = "canceled"
+	// Ghost member statuses, used by tier memberships.
+	GhostMemberStatusFree   = "free"
+	GhostMemberStatusPaid   = "paid"
+	GhostMemberStatusComped = "comped"
+)
+
+// podcasts returns the podcast IDs that gm grants access to, according to
+// the configured plans or tiers.
+func (c *Config) podcasts(gm GhostMembership) []uint {
+	if gm.TierID != "" {
+		return c.Tiers[gm.TierID]
+	}
+
+	return c.Plans[gm.PlanID]
+}
+
+// statusPolicy returns the configured policy for a Ghost status. See
+// [Config.StatusPolicies].
+func (c *Config) statusPolicy(status string) string {
+	policy, ok := c.StatusPolicies[status]
+	if ok {
+		return policy
+	}
+
+	policy, ok = DefaultStatusPolicies[status]
+	if ok {
+		return policy
+	}
+
+	return StatusPolicySuspend
+}
+
+// decide determines whether gm grants access as of now, and until when.
+//
+// The membership's status is looked up in [Config.StatusPolicies]. Statuses
+// with the grace policy, such as past_due, keep access until
+// [Config.GracePeriod] after Ghost last updated the subscription; that cutoff
+// becomes the subscription's expiry.
+//
+// A plan membership that is set to cancel at the end of its billing period
+// stays active until CurrentPeriodEnd, even if Ghost already reports it as
+// canceled. Its expiry is passed on to Castopod's expires_at column, so that
+// Castopod cuts off access itself even if this application stops running.
+func (c *Config) decide(gm GhostMembership, now time.Time) decision {
+	d := decision{reason: gm.reason(), membership: gm}
+
+	switch c.statusPolicy(gm.Status) {
+	case StatusPolicyGrant:
+		d.active = true
+	case StatusPolicyGrace:
+		end := gm.UpdatedAt.Add(time.Duration(c.GracePeriod))
+		if !gm.UpdatedAt.IsZero() && end.After(now) {
+			d.active = true
+			d.expiresAt = end
+			d.reason += fmt.Sprintf(", in grace period until %v", end.Format(CastopodTimeFormat))
+		}
+	}
+
+	if gm.TierID != "" || !gm.CancelAtPeriodEnd || gm.CurrentPeriodEnd.IsZero() {
+		return d
+	}
+
+	if gm.Status == GhostStatusCanceled && gm.CurrentPeriodEnd.After(now) {
+		d.active = true
+	}
+
+	if d.active {
+		d.active = gm.CurrentPeriodEnd.After(now)
+
+		if d.expiresAt.IsZero() || gm.CurrentPeriodEnd.Before(d.expiresAt) {
+			d.expiresAt = gm.CurrentPeriodEnd
+		}
+	}
+
+	return d
+}
+
+// reason describes gm for a [CastopodSubscription.Reason], such as "plan foo
+// is active" or "tier bar is comped".
+func (gm GhostMembership) reason() string {
+	if gm.TierID != "" {
+		return fmt.Sprintf("tier %v is %v", gm.TierID, gm.Status)
+	}
+
+	if gm.CancelAtPeriodEnd && !gm.CurrentPeriodEnd.IsZero() {
+		return fmt.Sprintf("plan %v is %v, canceling at %v", gm.PlanID, gm.Status, gm.CurrentPeriodEnd.Format(CastopodTimeFormat))
+	}
+
+	return fmt.Sprintf("plan %v is %v", gm.PlanID, gm.Status)
+}
+
+const (
+	// Reason recorded for subscriptions that are granted by
+	// [Config.BlessedAccounts].
+	ReasonBlessedAccount = "blessed account"
+	// Reason recorded for subscriptions whose email address has no Ghost
+	// membership and is not a blessed account.
+	ReasonOrphaned = "no ghost membership"
+	// Reason recorded for subscriptions whose email address still exists in
+	// Ghost or is blessed, but whose podcast is no longer granted by any
+	// plan, tier or blessed account.
+	ReasonUnentitled = "not granted by any plan, tier or blessed account"
+	// Reason recorded for subscriptions to a podcast that is not in
+	// [CastopodConfig.ManagedPodcasts].
+	ReasonUnmanaged = "podcast is not managed"
 )
 
+// managed returns true if subscriptions to podcastID may be suspended by the
+// reconciliation. See [CastopodConfig.ManagedPodcasts].
+func (c *Config) managed(podcastID uint) bool {
+	return len(c.CastopodConfig.ManagedPodcasts) == 0 || slices.Contains(c.CastopodConfig.ManagedPodcasts, podcastID)
+}
+
+// suspend marks s as suspended for reason, if it isn't already.
+func (c *Config) suspend(s CastopodSubscription, reason string) CastopodSubscription {
+	s.Reason = reason
+
+	if s.Status != CastopodStatusSuspended {
+		s.Status = CastopodStatusSuspended
+		s.UpdatedAt = time.Now()
+		s.UpdatedBy = c.CastopodConfig.UpdatedBy
+		s.Changed = true
+	}
+
+	return s
+}
+
+// decision is the resolved status of a single email+podcast pair.
+type decision struct {
+	active bool
+	reason string
+	// the membership that this decision was made from
+	membership GhostMembership
+	// only meaningful when active; the zero value means no expiry
+	expiresAt time.Time
+}
+
+// precedes returns true if d should be used instead of o. Any decision that
+// grants access wins over one that does not. Between two that grant access,
+// one that never expires wins, followed by the one that expires last. Any
+// remaining ties are broken by the reason, so that the outcome never depends
+// on the order of the Ghost memberships.
+func (d decision) precedes(o decision) bool {
+	if d.active != o.active {
+		return d.active
+	}
+
+	if d.active && !d.expiresAt.Equal(o.expiresAt) {
+		if d.expiresAt.IsZero() || o.expiresAt.IsZero() {
+			return d.expiresAt.IsZero()
+		}
+
+		return d.expiresAt.After(o.expiresAt)
+	}
+
+	return d.reason < o.reason
+}
+
+// newCastopodSubscription creates a subscription with a freshly generated
+// token that has not yet been assigned a status.
+func (c *Config) newCastopodSubscription(email string, podcastID uint) CastopodSubscription {
+	raw, t := castopod.NewToken()
+
+	return CastopodSubscription{
+		PodcastID: podcastID,
+		Email:     email,
+		Token:     t,
+		RawToken:  raw,
+		CreatedBy: c.CastopodConfig.CreatedBy,
+		CreatedAt: time.Now(),
+		Changed:   true,
+	}
+}
+
+// reissueToken gives a suspended subscription that is about to be reactivated
+// a new token when notifications are enabled for its podcast, since the
+// plaintext of its existing token is unknown and so its feed link could not be
+// sent. Subscriptions to podcasts without a template keep their token, since
+// nobody would be told the new one.
+func (c *Config) reissueToken(s *CastopodSubscription) {
+	if !c.Notifications.enabled() || s.Status != CastopodStatusSuspended || s.RawToken != "" {
+		return
+	}
+
+	_, ok := c.Notifications.Templates[s.PodcastID]
+	if !ok {
+		return
+	}
+
+	s.RawToken, s.Token = castopod.NewToken()
+}
+
 // GetCastopodSubscriptions accepts a list of all Ghost memberships and all
-// current Castopod subscriptions, and returns the final list.
+// current Castopod subscriptions, and returns the final list, sorted by email
+// and then podcast ID. See [Config.GetSyncPlan] for how the list is determined.
 func (c *Config) GetCastopodSubscriptions(gms []GhostMembership, cms []CastopodSubscription) []CastopodSubscription {
+	p := c.GetSyncPlan(gms, cms)
+
+	return p.Subscriptions()
+}
+
+// GetSyncPlan accepts a list of all Ghost memberships and all current Castopod
+// subscriptions, and returns a [SyncPlan] describing the final state of every
+// subscription and why.
+//
+// When several memberships for the same email grant the same podcast, such as
+// an old canceled subscription and a new active one for the same plan, the
+// result is deterministic: any membership that grants access wins over those
+// that do not, and the winning membership is recorded in each subscription's
+// Reason. Blessed accounts always win.
+//
+// Existing subscriptions to a managed podcast that are not granted by any
+// membership or blessed account are suspended, including those whose plan or
+// tier no longer includes the podcast in the config. See
+// [CastopodConfig.ManagedPodcasts] and [CastopodConfig.OrphanPolicy].
+func (c *Config) GetSyncPlan(gms []GhostMembership, cms []CastopodSubscription) SyncPlan {
 	// This needs an interesting data structure. There has to be a
 	// one-to-many mapping between each Ghost membership and Castopod
 	// subscriptions. This is because the user can configure multiple podcast
@@ -191,79 +816,127 @@ func (c *Config) GetCastopodSubscriptions(gms []GhostMembership, cms []CastopodS
 	// abc@example.com = []foo + []bar = 1,2,3,4
 	// def@example.com = []foo = 1,2
 
+	plan := SyncPlan{}
+	now := time.Now()
+
 	// define a mapping between emails and the granted plan ID's
 	emails := make(map[string]map[uint]CastopodSubscription)
 
+	// each subscription as it was before any changes were made
+	before := make(map[string]map[uint]CastopodSubscription)
+
 	// start by iterating through the existing castopod subscriptions. This data
 	// structure allows us to quickly identify the subscriptions that already
 	// exist for each email address.
 	for _, s := range cms {
 		if s.Email == "" {
+			plan.Skipped = append(plan.Skipped, SyncPlanSkip{PodcastID: s.PodcastID, Reason: "castopod subscription has no email"})
 			continue
 		}
 
 		_, ok := emails[s.Email]
 		if !ok {
 			emails[s.Email] = make(map[uint]CastopodSubscription)
+			before[s.Email] = make(map[uint]CastopodSubscription)
 		}
 
 		emails[s.Email][s.PodcastID] = s
+		before[s.Email][s.PodcastID] = s
 	}
 
-	// now that we have a list of all the email addresses in castopod and their
-	// corresponding subscriptions, we can iterate through the ghost membership
-	// listings and determine which gaps need to be filled.
+	// before touching any subscriptions, resolve the desired status of every
+	// email+podcast pair from the ghost memberships. See [decision.precedes]
+	// for how conflicting memberships are resolved.
+	decisions := make(map[string]map[uint]decision)
+
+	// every email address that has at least one ghost membership, even for
+	// plans that aren't configured
+	members := make(map[string]bool)
+
 	for _, gm := range gms {
 		if gm.Email == "" {
+			plan.Skipped = append(plan.Skipped, SyncPlanSkip{PlanID: gm.PlanID, TierID: gm.TierID, Reason: "ghost membership has no email"})
 			continue
 		}
 
-		// iterate through all of the user-configured plan IDs, and those plans'
-		// corresponding podcast IDs
-		for _, p := range c.Plans[gm.PlanID] {
-			_, ok := emails[gm.Email]
+		members[gm.Email] = true
+
+		d := c.decide(gm, now)
+
+		if len(c.podcasts(gm)) == 0 {
+			plan.Skipped = append(plan.Skipped, SyncPlanSkip{Email: gm.Email, PlanID: gm.PlanID, TierID: gm.TierID, Reason: gm.reason() + ", but grants no podcasts"})
+		}
+
+		// iterate through all of the user-configured plan or tier IDs, and
+		// their corresponding podcast IDs
+		for _, p := range c.podcasts(gm) {
+			_, ok := decisions[gm.Email]
 			if !ok {
-				emails[gm.Email] = make(map[uint]CastopodSubscription)
+				decisions[gm.Email] = make(map[uint]decision)
+			}
+
+			o, ok := decisions[gm.Email][p]
+			if !ok || d.precedes(o) {
+				decisions[gm.Email][p] = d
 			}
+		}
+	}
 
-			s, ok := emails[gm.Email][p]
+	// now that we have a list of all the email addresses in castopod and their
+	// corresponding subscriptions, we can apply the decisions and determine
+	// which gaps need to be filled. Blessed accounts are left to the loop
+	// below, since they always win; applying a decision to them first would
+	// make every run look like a change, such as suspending a blessed account
+	// with a canceled plan only to reactivate it with a new token.
+	for email, ds := range decisions {
+		for p, d := range ds {
+			if slices.Contains(c.BlessedAccounts[email], p) {
+				continue
+			}
+
+			_, ok := emails[email]
 			if !ok {
-				_, t := castopod.NewToken()
-				s = CastopodSubscription{
-					PodcastID: p,
-					Email:     gm.Email,
-					Token:     t,
-					CreatedBy: c.CastopodConfig.CreatedBy,
-					CreatedAt: time.Now(),
-					Changed:   true,
-				}
+				emails[email] = make(map[uint]CastopodSubscription)
+			}
+
+			s, ok := emails[email][p]
+			if !ok {
+				s = c.newCastopodSubscription(email, p)
 			}
 
-			newStatus := ""
-			if gm.Status == GhostStatusActive {
+			newStatus := CastopodStatusSuspended
+			if d.active {
 				newStatus = CastopodStatusActive
-			} else {
-				newStatus = CastopodStatusSuspended
 			}
 
 			// only make a change if we need to, otherwise the database
-			// will auto-increment out of control on each update
-			if newStatus != s.Status {
+			// will auto-increment out of control on each update. The
+			// expiry is left alone when suspending, since it no longer
+			// matters.
+			if newStatus != s.Status || (d.active && !s.ExpiresAt.Equal(d.expiresAt)) {
+				if d.active {
+					c.reissueToken(&s)
+				}
 				s.Status = newStatus
+				if d.active {
+					s.ExpiresAt = d.expiresAt
+				}
 				s.UpdatedAt = time.Now()
 				s.UpdatedBy = c.CastopodConfig.UpdatedBy
 				s.Changed = true
-			} else {
-				s.Changed = false
 			}
 
-			emails[gm.Email][p] = s
+			s.Reason = d.reason
+			s.Membership = d.membership
+
+			emails[email][p] = s
 		}
 	}
 
 	// introduce the blessed accounts
 	for email, ids := range c.BlessedAccounts {
 		if email == "" {
+			plan.Skipped = append(plan.Skipped, SyncPlanSkip{Reason: "blessed account has no email"})
 			continue
 		}
 
@@ -279,38 +952,102 @@ func (c *Config) GetCastopodSubscriptions(gms []GhostMembership, cms []CastopodS
 
 			s, ok := emails[email][p]
 			if !ok {
-				_, t := castopod.NewToken()
-				s = CastopodSubscription{
-					PodcastID: p,
-					Email:     email,
-					Token:     t,
-					CreatedBy: c.CastopodConfig.CreatedBy,
-					CreatedAt: time.Now(),
-					Changed:   true,
-				}
+				s = c.newCastopodSubscription(email, p)
 			}
 
 			// only make a change if we need to, otherwise the database
 			// will auto-increment out of control on each update
-			if s.Status != CastopodStatusActive {
+			if s.Status != CastopodStatusActive || !s.ExpiresAt.IsZero() {
+				c.reissueToken(&s)
 				s.Changed = true
 				s.Status = CastopodStatusActive
+				s.ExpiresAt = time.Time{}
 				s.UpdatedAt = time.Now()
 				s.UpdatedBy = c.CastopodConfig.UpdatedBy
 			}
 
+			s.Reason = ReasonBlessedAccount
+			s.Membership = GhostMembership{}
+
 			emails[email][p] = s
 		}
 	}
 
-	// finally, flatten the map so we can produce a list of castopod
-	// subscriptions
-	cs := []CastopodSubscription{}
-	for _, sub := range emails {
-		for i := range sub {
-			cs = append(cs, sub[i])
+	// finally, handle whatever wasn't decided above. This is either an orphan
+	// (an email address that no longer exists in ghost at all, such as a
+	// deleted member) or a subscription that is no longer entitled, such as
+	// when a podcast was removed from a plan in the config.
+	for email, subs := range emails {
+		_, blessed := c.BlessedAccounts[email]
+		orphan := !members[email] && !blessed
+
+		for p, s := range subs {
+			_, decided := decisions[email][p]
+			if decided || slices.Contains(c.BlessedAccounts[email], p) {
+				continue
+			}
+
+			if !c.managed(p) {
+				s.Reason = ReasonUnmanaged
+				subs[p] = s
+
+				continue
+			}
+
+			if orphan {
+				if c.CastopodConfig.OrphanPolicy == OrphanPolicyFlag {
+					s.Reason = ReasonOrphaned
+				} else {
+					s = c.suspend(s, ReasonOrphaned)
+				}
+
+				s.Orphaned = true
+				subs[p] = s
+
+				continue
+			}
+
+			subs[p] = c.suspend(s, ReasonUnentitled)
 		}
 	}
 
-	return cs
+	// flatten the map into the plan
+	for email, subs := range emails {
+		for p, sub := range subs {
+			old, existed := before[email][p]
+			if existed {
+				sub.OldStatus = old.Status
+			}
+
+			e := SyncPlanEntry{
+				Email:        email,
+				PodcastID:    p,
+				NewStatus:    sub.Status,
+				ExpiresAt:    formatExpiresAt(sub),
+				Reason:       sub.Reason,
+				Orphaned:     sub.Orphaned,
+				Subscription: sub,
+			}
+
+			if existed {
+				e.OldStatus = old.Status
+				e.Previous = old
+			}
+
+			switch {
+			case !existed:
+				plan.Creates = append(plan.Creates, e)
+			case sub.Changed:
+				plan.StatusChanges = append(plan.StatusChanges, e)
+			default:
+				plan.Unchanged = append(plan.Unchanged, e)
+			}
+		}
+	}
+
+	// ranging over maps is random, so sort everything to keep the output
+	// stable from one run to the next
+	plan.sort()
+
+	return plan
 }
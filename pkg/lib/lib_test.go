@@ -1,7 +1,14 @@
 package ghosttocastopod_test
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
 	"testing"
+	"time"
 
 	ghosttocastopod "github.com/charles-m-knox/ghost-to-castopod/pkg/lib"
 )
@@ -29,6 +36,7 @@ func TestProcessGhostMembership(t *testing.T) {
 		{tc, ghosttocastopod.GhostMembership{Email: "baz", Status: ""}, true},
 		{tc, ghosttocastopod.GhostMembership{Email: "baz", Status: "abc", PlanID: ""}, true},
 		{tc, ghosttocastopod.GhostMembership{Email: "baz", Status: "abc", PlanID: "def"}, false},
+		{tc, ghosttocastopod.GhostMembership{Email: "baz", Status: "abc", TierID: "def"}, false},
 	}
 
 	for i, test := range tests {
@@ -53,6 +61,7 @@ func TestApplyDefaults(t *testing.T) {
 		{ghosttocastopod.Config{}, ghosttocastopod.Config{CastopodConfig: ghosttocastopod.CastopodConfig{CreatedBy: 1, UpdatedBy: 1}}},
 		{ghosttocastopod.Config{CastopodConfig: ghosttocastopod.CastopodConfig{CreatedBy: 21, UpdatedBy: 21}}, ghosttocastopod.Config{CastopodConfig: ghosttocastopod.CastopodConfig{CreatedBy: 21, UpdatedBy: 21}}},
 		{ghosttocastopod.Config{Plans: map[string][]uint{"foo": {2, 3, 1}}}, ghosttocastopod.Config{Plans: map[string][]uint{"foo": {1, 2, 3}}, CastopodConfig: ghosttocastopod.CastopodConfig{CreatedBy: 1, UpdatedBy: 1}}},
+		{ghosttocastopod.Config{Tiers: map[string][]uint{"baz": {2, 3, 1}}}, ghosttocastopod.Config{Tiers: map[string][]uint{"baz": {1, 2, 3}}, CastopodConfig: ghosttocastopod.CastopodConfig{CreatedBy: 1, UpdatedBy: 1}}},
 		{ghosttocastopod.Config{BlessedAccounts: map[string][]uint{"bar": {2, 3, 1}}}, ghosttocastopod.Config{BlessedAccounts: map[string][]uint{"bar": {1, 2, 3}}, CastopodConfig: ghosttocastopod.CastopodConfig{CreatedBy: 1, UpdatedBy: 1}}},
 	}
 
@@ -69,6 +78,23 @@ func TestApplyDefaults(t *testing.T) {
 			t.Fail()
 		}
 
+		for status, policy := range ghosttocastopod.DefaultStatusPolicies {
+			if test.c.StatusPolicies[status] != policy {
+				t.Logf("test %v failed: StatusPolicies[%v] mismatch, got %v, want %v", i, status, test.c.StatusPolicies[status], policy)
+				t.Fail()
+			}
+		}
+
+		if test.c.CastopodConfig.OrphanPolicy != ghosttocastopod.OrphanPolicySuspend {
+			t.Logf("test %v failed: CastopodConfig.OrphanPolicy mismatch, got %v, want %v", i, test.c.CastopodConfig.OrphanPolicy, ghosttocastopod.OrphanPolicySuspend)
+			t.Fail()
+		}
+
+		if test.c.Daemon.Interval != ghosttocastopod.Duration(ghosttocastopod.DefaultDaemonInterval) || test.c.Daemon.ShutdownTimeout != ghosttocastopod.Duration(ghosttocastopod.DefaultDaemonShutdownTimeout) {
+			t.Logf("test %v failed: Daemon mismatch, got %+v", i, test.c.Daemon)
+			t.Fail()
+		}
+
 		for k, v := range test.c.Plans {
 			l := len(v)
 			wl := len(test.want.Plans[k])
@@ -83,6 +109,13 @@ func TestApplyDefaults(t *testing.T) {
 				}
 			}
 		}
+
+		for k, v := range test.c.Tiers {
+			if !slices.Equal(v, test.want.Tiers[k]) {
+				t.Logf("test %v failed: configured tiers mismatch, got %v, want %v", i, test.c.Tiers, test.want.Tiers)
+				t.Fail()
+			}
+		}
 	}
 }
 
@@ -279,3 +312,444 @@ func TestGetCastopodSubscriptions(t *testing.T) {
 
 	}
 }
+
+func TestGetCastopodSubscriptionsTiers(t *testing.T) {
+	t.Parallel()
+
+	const comped = "comped@example.com"
+	const paid = "paid@example.com"
+	const free = "free@example.com"
+
+	tc := ghosttocastopod.Config{
+		Plans: map[string][]uint{"plan": {1}},
+		Tiers: map[string][]uint{"tier": {2, 3}},
+	}
+	tc.ApplyDefaults()
+
+	tgm := []ghosttocastopod.GhostMembership{
+		{Email: comped, Status: ghosttocastopod.GhostMemberStatusComped, TierID: "tier"},
+		{Email: paid, Status: ghosttocastopod.GhostMemberStatusPaid, TierID: "tier"},
+		{Email: free, Status: ghosttocastopod.GhostMemberStatusFree, TierID: "tier"},
+		{Email: free, Status: gActive, TierID: "unconfigured"},
+	}
+
+	tcs := []ghosttocastopod.CastopodSubscription{
+		{Email: free, PodcastID: 2, Status: cActive},
+	}
+
+	want := map[string]map[uint]string{
+		comped: {2: cActive, 3: cActive},
+		paid:   {2: cActive, 3: cActive},
+		free:   {2: cSusp, 3: cSusp},
+	}
+
+	got := tc.GetCastopodSubscriptions(tgm, tcs)
+
+	if len(got) != 6 {
+		t.Fatalf("result length mismatch, got %v, want %v", len(got), 6)
+	}
+
+	for _, g := range got {
+		if g.Status != want[g.Email][g.PodcastID] {
+			t.Errorf("status mismatch for %v podcast %v, got %v, want %v", g.Email, g.PodcastID, g.Status, want[g.Email][g.PodcastID])
+		}
+	}
+}
+
+func TestGetCastopodSubscriptionsPrecedence(t *testing.T) {
+	t.Parallel()
+
+	const email = "foo@example.com"
+
+	tc := ghosttocastopod.Config{
+		Plans: map[string][]uint{"old": {1}, "new": {1, 2}},
+	}
+	tc.ApplyDefaults()
+
+	canceled := ghosttocastopod.GhostMembership{Email: email, Status: "canceled", PlanID: "old"}
+	pastDue := ghosttocastopod.GhostMembership{Email: email, Status: "past_due", PlanID: "new"}
+	active := ghosttocastopod.GhostMembership{Email: email, Status: gActive, PlanID: "new"}
+	activeOld := ghosttocastopod.GhostMembership{Email: email, Status: gActive, PlanID: "old"}
+
+	tcs := []ghosttocastopod.CastopodSubscription{
+		{Email: email, PodcastID: 1, Status: cSusp},
+	}
+
+	tests := []struct {
+		gms    []ghosttocastopod.GhostMembership
+		status string
+		reason string
+	}{
+		{[]ghosttocastopod.GhostMembership{canceled, active}, cActive, "plan new is active"},
+		{[]ghosttocastopod.GhostMembership{active, canceled}, cActive, "plan new is active"},
+		{[]ghosttocastopod.GhostMembership{active, activeOld}, cActive, "plan new is active"},
+		{[]ghosttocastopod.GhostMembership{activeOld, active}, cActive, "plan new is active"},
+		{[]ghosttocastopod.GhostMembership{canceled, pastDue}, cSusp, "plan new is past_due"},
+		{[]ghosttocastopod.GhostMembership{pastDue, canceled}, cSusp, "plan new is past_due"},
+	}
+
+	for i, test := range tests {
+		got := tc.GetCastopodSubscriptions(test.gms, tcs)
+
+		for _, g := range got {
+			if g.PodcastID != 1 {
+				continue
+			}
+
+			if g.Status != test.status {
+				t.Errorf("test %v failed: Status mismatch, got %v, want %v", i, g.Status, test.status)
+			}
+
+			if g.Reason != test.reason {
+				t.Errorf("test %v failed: Reason mismatch, got %v, want %v", i, g.Reason, test.reason)
+			}
+
+			if g.Changed != (test.status != cSusp) {
+				t.Errorf("test %v failed: Changed mismatch, got %v", i, g.Changed)
+			}
+		}
+	}
+}
+
+func TestGetCastopodSubscriptionsOrphans(t *testing.T) {
+	t.Parallel()
+
+	const member = "member@example.com"
+	const deleted = "deleted@example.com"
+	const unconfigured = "unconfigured@example.com"
+	const admin = "admin@example.com"
+
+	tgm := []ghosttocastopod.GhostMembership{
+		{Email: member, Status: gActive, PlanID: "plan"},
+		// a membership for a plan that isn't configured still counts as
+		// existing in ghost
+		{Email: unconfigured, Status: gActive, PlanID: "other"},
+	}
+
+	tcs := []ghosttocastopod.CastopodSubscription{
+		{Email: member, PodcastID: 1, Status: cActive},
+		{Email: deleted, PodcastID: 1, Status: cActive},
+		{Email: deleted, PodcastID: 2, Status: cSusp},
+		{Email: unconfigured, PodcastID: 1, Status: cActive},
+		{Email: admin, PodcastID: 1, Status: cActive},
+	}
+
+	tests := []struct {
+		policy string
+		status string
+	}{
+		{ghosttocastopod.OrphanPolicySuspend, cSusp},
+		{ghosttocastopod.OrphanPolicyFlag, cActive},
+	}
+
+	for i, test := range tests {
+		tc := ghosttocastopod.Config{
+			Plans:           map[string][]uint{"plan": {1}},
+			BlessedAccounts: map[string][]uint{admin: {1}},
+			CastopodConfig:  ghosttocastopod.CastopodConfig{OrphanPolicy: test.policy},
+		}
+		tc.ApplyDefaults()
+
+		got := tc.GetCastopodSubscriptions(tgm, tcs)
+
+		if len(got) != len(tcs) {
+			t.Fatalf("test %v failed: result length mismatch, got %v, want %v", i, len(got), len(tcs))
+		}
+
+		for _, g := range got {
+			orphan := g.Email == deleted
+
+			if g.Orphaned != orphan {
+				t.Errorf("test %v failed: Orphaned mismatch for %v, got %v, want %v", i, g.Email, g.Orphaned, orphan)
+			}
+
+			// not orphaned, but no longer entitled to anything
+			if g.Email == unconfigured {
+				if g.Status != cSusp || g.Reason != ghosttocastopod.ReasonUnentitled {
+					t.Errorf("test %v failed: unentitled %v was not suspended: %+v", i, g.Email, g)
+				}
+				continue
+			}
+
+			if !orphan {
+				if g.Status != cActive || g.Changed {
+					t.Errorf("test %v failed: non-orphan %v was changed: %+v", i, g.Email, g)
+				}
+				continue
+			}
+
+			if g.Reason != ghosttocastopod.ReasonOrphaned {
+				t.Errorf("test %v failed: Reason mismatch, got %v", i, g.Reason)
+			}
+
+			want := test.status
+			if g.PodcastID == 2 {
+				want = cSusp
+			}
+
+			if g.Status != want {
+				t.Errorf("test %v failed: Status mismatch for podcast %v, got %v, want %v", i, g.PodcastID, g.Status, want)
+			}
+
+			if g.Changed != (g.PodcastID == 1 && test.policy == ghosttocastopod.OrphanPolicySuspend) {
+				t.Errorf("test %v failed: Changed mismatch for podcast %v, got %v", i, g.PodcastID, g.Changed)
+			}
+		}
+	}
+}
+
+func TestGetCastopodSubscriptionsUnentitled(t *testing.T) {
+	t.Parallel()
+
+	const member = "member@example.com"
+	const admin = "admin@example.com"
+
+	// podcast 3 used to be a part of the plan, and podcast 9 is not managed
+	// by this application at all
+	tc := ghosttocastopod.Config{
+		Plans:           map[string][]uint{"plan": {1, 2}},
+		BlessedAccounts: map[string][]uint{admin: {1}},
+		CastopodConfig:  ghosttocastopod.CastopodConfig{ManagedPodcasts: []uint{1, 2, 3}},
+	}
+	tc.ApplyDefaults()
+
+	tgm := []ghosttocastopod.GhostMembership{
+		{Email: member, Status: gActive, PlanID: "plan"},
+	}
+
+	tcs := []ghosttocastopod.CastopodSubscription{
+		{Email: member, PodcastID: 1, Status: cActive},
+		{Email: member, PodcastID: 3, Status: cActive},
+		{Email: member, PodcastID: 9, Status: cActive},
+		{Email: admin, PodcastID: 1, Status: cActive},
+		{Email: admin, PodcastID: 3, Status: cActive},
+	}
+
+	want := map[string]map[uint]string{
+		member: {1: cActive, 2: cActive, 3: cSusp, 9: cActive},
+		admin:  {1: cActive, 3: cSusp},
+	}
+
+	got := tc.GetCastopodSubscriptions(tgm, tcs)
+
+	if len(got) != 6 {
+		t.Fatalf("result length mismatch, got %v, want %v", len(got), 6)
+	}
+
+	for _, g := range got {
+		if g.Status != want[g.Email][g.PodcastID] {
+			t.Errorf("status mismatch for %v podcast %v, got %v, want %v", g.Email, g.PodcastID, g.Status, want[g.Email][g.PodcastID])
+		}
+
+		if g.PodcastID == 3 && (g.Reason != ghosttocastopod.ReasonUnentitled || !g.Changed) {
+			t.Errorf("unentitled subscription for %v was not suspended: %+v", g.Email, g)
+		}
+
+		if g.PodcastID == 9 && g.Changed {
+			t.Errorf("unmanaged subscription for %v was changed: %+v", g.Email, g)
+		}
+	}
+}
+
+func TestGetCastopodSubscriptionsExpiry(t *testing.T) {
+	t.Parallel()
+
+	const canceling = "canceling@example.com"
+	const canceled = "canceled@example.com"
+	const lapsed = "lapsed@example.com"
+	const renewed = "renewed@example.com"
+	const unchanged = "unchanged@example.com"
+	const admin = "admin@example.com"
+
+	future := time.Now().Add(72 * time.Hour).Truncate(time.Second).UTC()
+	past := time.Now().Add(-72 * time.Hour).Truncate(time.Second).UTC()
+
+	tc := ghosttocastopod.Config{
+		Plans:           map[string][]uint{"plan": {1}, "other": {1}},
+		BlessedAccounts: map[string][]uint{admin: {1}},
+	}
+	tc.ApplyDefaults()
+
+	tgm := []ghosttocastopod.GhostMembership{
+		{Email: canceling, Status: gActive, PlanID: "plan", CurrentPeriodEnd: future, CancelAtPeriodEnd: true},
+		{Email: canceled, Status: ghosttocastopod.GhostStatusCanceled, PlanID: "plan", CurrentPeriodEnd: future, CancelAtPeriodEnd: true},
+		{Email: lapsed, Status: ghosttocastopod.GhostStatusCanceled, PlanID: "plan", CurrentPeriodEnd: past, CancelAtPeriodEnd: true},
+		{Email: renewed, Status: gActive, PlanID: "plan", CurrentPeriodEnd: future, CancelAtPeriodEnd: true},
+		{Email: renewed, Status: gActive, PlanID: "other", CurrentPeriodEnd: future},
+		{Email: unchanged, Status: gActive, PlanID: "plan", CurrentPeriodEnd: future, CancelAtPeriodEnd: true},
+	}
+
+	tcs := []ghosttocastopod.CastopodSubscription{
+		{Email: lapsed, PodcastID: 1, Status: cActive, ExpiresAt: past},
+		{Email: renewed, PodcastID: 1, Status: cActive, ExpiresAt: future},
+		{Email: unchanged, PodcastID: 1, Status: cActive, ExpiresAt: future},
+		{Email: admin, PodcastID: 1, Status: cActive, ExpiresAt: future},
+	}
+
+	tests := map[string]struct {
+		status    string
+		expiresAt time.Time
+		changed   bool
+	}{
+		canceling: {cActive, future, true},
+		canceled:  {cActive, future, true},
+		lapsed:    {cSusp, past, true},
+		renewed:   {cActive, time.Time{}, true},
+		unchanged: {cActive, future, false},
+		admin:     {cActive, time.Time{}, true},
+	}
+
+	got := tc.GetCastopodSubscriptions(tgm, tcs)
+
+	if len(got) != len(tests) {
+		t.Fatalf("result length mismatch, got %v, want %v", len(got), len(tests))
+	}
+
+	for _, g := range got {
+		want := tests[g.Email]
+
+		if g.Status != want.status || !g.ExpiresAt.Equal(want.expiresAt) || g.Changed != want.changed {
+			t.Errorf("%v mismatch, got status=%v expiresAt=%v changed=%v, want status=%v expiresAt=%v changed=%v", g.Email, g.Status, g.ExpiresAt, g.Changed, want.status, want.expiresAt, want.changed)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	tests := []struct {
+		json string
+		err  bool
+	}{
+		{`{"gracePeriod": "72h", "statusPolicies": {"unpaid": "grace"}}`, false},
+		{`{"gracePeriod": 72}`, true},
+		{`{"gracePeriod": "-1h"}`, true},
+		{`{"statusPolicies": {"unpaid": "maybe"}}`, true},
+		{`{"castopodConfig": {"orphanPolicy": "delete"}}`, true},
+	}
+
+	for i, test := range tests {
+		f := filepath.Join(dir, fmt.Sprintf("config%v.json", i))
+		err := os.WriteFile(f, []byte(test.json), 0o600)
+		if err != nil {
+			t.Fatalf("failed to write test config: %v", err)
+		}
+
+		c, err := ghosttocastopod.LoadConfig(f)
+		if err != nil && !test.err {
+			t.Errorf("test %v failed: received unexpected err: %v", i, err)
+		} else if err == nil && test.err {
+			t.Errorf("test %v failed: did not receive error but wanted one", i)
+		}
+
+		if i == 0 {
+			if time.Duration(c.GracePeriod) != 72*time.Hour {
+				t.Errorf("test %v failed: GracePeriod mismatch, got %v", i, time.Duration(c.GracePeriod))
+			}
+
+			if c.StatusPolicies["unpaid"] != ghosttocastopod.StatusPolicyGrace || c.StatusPolicies["active"] != ghosttocastopod.StatusPolicyGrant {
+				t.Errorf("test %v failed: unexpected StatusPolicies: %v", i, c.StatusPolicies)
+			}
+		}
+	}
+}
+
+func TestGetCastopodSubscriptionsGracePeriod(t *testing.T) {
+	t.Parallel()
+
+	const declined = "declined@example.com"
+	const expired = "expired@example.com"
+	const unknown = "unknown@example.com"
+	const trialing = "trialing@example.com"
+	const unpaid = "unpaid@example.com"
+
+	now := time.Now().Truncate(time.Second).UTC()
+
+	tc := ghosttocastopod.Config{
+		Plans:          map[string][]uint{"plan": {1}},
+		GracePeriod:    ghosttocastopod.Duration(72 * time.Hour),
+		StatusPolicies: map[string]string{"unpaid": ghosttocastopod.StatusPolicyGrant},
+	}
+	tc.ApplyDefaults()
+
+	tgm := []ghosttocastopod.GhostMembership{
+		{Email: declined, Status: "past_due", PlanID: "plan", UpdatedAt: now.Add(-time.Hour)},
+		{Email: expired, Status: "past_due", PlanID: "plan", UpdatedAt: now.Add(-96 * time.Hour)},
+		{Email: unknown, Status: "past_due", PlanID: "plan"},
+		{Email: trialing, Status: "trialing", PlanID: "plan"},
+		{Email: unpaid, Status: "unpaid", PlanID: "plan"},
+	}
+
+	tcs := []ghosttocastopod.CastopodSubscription{
+		{Email: declined, PodcastID: 1, Status: cActive},
+		{Email: expired, PodcastID: 1, Status: cActive},
+		{Email: unknown, PodcastID: 1, Status: cActive},
+	}
+
+	tests := map[string]struct {
+		status    string
+		expiresAt time.Time
+	}{
+		declined: {cActive, now.Add(71 * time.Hour)},
+		expired:  {cSusp, time.Time{}},
+		unknown:  {cSusp, time.Time{}},
+		trialing: {cActive, time.Time{}},
+		unpaid:   {cActive, time.Time{}},
+	}
+
+	got := tc.GetCastopodSubscriptions(tgm, tcs)
+
+	if len(got) != len(tests) {
+		t.Fatalf("result length mismatch, got %v, want %v", len(got), len(tests))
+	}
+
+	for _, g := range got {
+		want := tests[g.Email]
+
+		if g.Status != want.status || !g.ExpiresAt.Equal(want.expiresAt) {
+			t.Errorf("%v mismatch, got status=%v expiresAt=%v, want status=%v expiresAt=%v (%v)", g.Email, g.Status, g.ExpiresAt, want.status, want.expiresAt, g.Reason)
+		}
+	}
+}
+
+func TestGetCastopodSubscriptionsRawToken(t *testing.T) {
+	t.Parallel()
+
+	tc := ghosttocastopod.Config{
+		Plans:           map[string][]uint{"plan": {1}},
+		BlessedAccounts: map[string][]uint{"admin@example.com": {1}},
+	}
+	tc.ApplyDefaults()
+
+	tgm := []ghosttocastopod.GhostMembership{
+		{Email: "new@example.com", Status: gActive, PlanID: "plan"},
+		{Email: "existing@example.com", Status: gActive, PlanID: "plan"},
+	}
+
+	tcs := []ghosttocastopod.CastopodSubscription{
+		{Email: "existing@example.com", PodcastID: 1, Status: cSusp, Token: "stored"},
+	}
+
+	for _, g := range tc.GetCastopodSubscriptions(tgm, tcs) {
+		switch g.Email {
+		case "existing@example.com":
+			// the plaintext of an existing token is never known
+			if g.RawToken != "" || g.Token != "stored" {
+				t.Errorf("%v: existing token was changed: %+v", g.Email, g)
+			}
+		default:
+			h := sha256.Sum256([]byte(g.RawToken))
+			if g.RawToken == "" || hex.EncodeToString(h[:]) != g.Token {
+				t.Errorf("%v: raw token does not match the stored hash: %+v", g.Email, g)
+			}
+
+			for _, a := range g.Args() {
+				if a == g.RawToken {
+					t.Errorf("%v: raw token would be written to castopod", g.Email)
+				}
+			}
+		}
+	}
+}
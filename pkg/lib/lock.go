@@ -0,0 +1,91 @@
+package ghosttocastopod
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// LockConfig configures the advisory lock that keeps syncs on different hosts
+// from writing to the Castopod database at the same time. See
+// [Config.AcquireRunLock].
+type LockConfig struct {
+	// The name of the lock. Every host that syncs to the same Castopod
+	// database must use the same name. Defaults to [DefaultLockName].
+	Name string `json:"name"`
+	// How long to wait for another host to release the lock. Defaults to 0,
+	// which gives up immediately if the lock is held. MySQL waits in whole
+	// seconds, so this is rounded up.
+	Timeout Duration `json:"timeout"`
+}
+
+const DefaultLockName = "ghost-to-castopod"
+
+// ErrLockHeld is returned by [Config.AcquireRunLock] when another run held the
+// lock for the whole timeout.
+var ErrLockHeld = errors.New("another run holds the lock")
+
+// RunLock is a held advisory lock. MySQL ties named locks to the session that
+// acquired them, so it keeps a dedicated connection open until released.
+type RunLock struct {
+	conn *sql.Conn
+	name string
+}
+
+// AcquireRunLock acquires the named lock from [CastopodConfig.Lock] on db
+// using MySQL's GET_LOCK, waiting up to the configured timeout. Hold it
+// around the whole read-reconcile-write cycle and release it with
+// [RunLock.Release]. If the lock is still held by another session after the
+// timeout, the returned error wraps [ErrLockHeld]. MySQL also releases the
+// lock if the connection is lost, such as when the process exits.
+func (c *Config) AcquireRunLock(ctx context.Context, db *sql.DB) (*RunLock, error) {
+	return c.acquireRunLock(ctx, db, c.CastopodConfig.Lock.Timeout)
+}
+
+// acquireRunLock is [Config.AcquireRunLock] with a different timeout.
+func (c *Config) acquireRunLock(ctx context.Context, db *sql.DB, wait Duration) (*RunLock, error) {
+	lc := c.CastopodConfig.Lock
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get a connection for the lock: %v", err)
+	}
+
+	timeout := int64(math.Ceil(time.Duration(wait).Seconds()))
+
+	var got sql.NullInt64
+
+	err = conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", lc.Name, timeout).Scan(&got)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to acquire lock %v: %v", lc.Name, err)
+	}
+
+	if !got.Valid {
+		conn.Close()
+		return nil, fmt.Errorf("failed to acquire lock %v: GET_LOCK returned NULL", lc.Name)
+	}
+
+	if got.Int64 != 1 {
+		conn.Close()
+		return nil, fmt.Errorf("lock %v: %w", lc.Name, ErrLockHeld)
+	}
+
+	return &RunLock{conn: conn, name: lc.Name}, nil
+}
+
+// Release releases the lock and closes its connection. It still releases the
+// lock if ctx has already been canceled.
+func (l *RunLock) Release(ctx context.Context) error {
+	defer l.conn.Close()
+
+	_, err := l.conn.ExecContext(context.WithoutCancel(ctx), "DO RELEASE_LOCK(?)", l.name)
+	if err != nil {
+		return fmt.Errorf("failed to release lock %v: %v", l.name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,61 @@
+package ghosttocastopod_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	ghosttocastopod "github.com/charles-m-knox/ghost-to-castopod/pkg/lib"
+)
+
+func TestAcquireRunLock(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		result driver.Value
+		held   bool
+		err    bool
+	}{
+		{int64(1), false, false},
+		{int64(0), true, true},
+		{nil, false, true},
+	}
+
+	for i, test := range tests {
+		f := &fakeDB{
+			rows: map[string]*fakeRows{
+				"GET_LOCK": {columns: []string{"GET_LOCK"}, values: [][]driver.Value{{test.result}}},
+			},
+		}
+		db := f.open()
+
+		c := ghosttocastopod.Config{}
+		c.CastopodConfig.Lock.Timeout = ghosttocastopod.Duration(1500 * time.Millisecond)
+		c.ApplyDefaults()
+
+		l, err := c.AcquireRunLock(context.Background(), db)
+		if errors.Is(err, ghosttocastopod.ErrLockHeld) != test.held {
+			t.Errorf("test %v failed: lock held mismatch, got err %v", i, err)
+		}
+
+		if (err != nil) != test.err {
+			t.Errorf("test %v failed: unexpected err: %v", i, err)
+		}
+
+		if err == nil {
+			err = l.Release(context.Background())
+			if err != nil {
+				t.Errorf("test %v failed: failed to release: %v", i, err)
+			}
+
+			if len(f.execs) != 1 || !strings.Contains(f.execs[0].query, "RELEASE_LOCK") || f.execs[0].args[0] != ghosttocastopod.DefaultLockName {
+				t.Errorf("test %v failed: lock was not released: %+v", i, f.execs)
+			}
+		}
+
+		db.Close()
+	}
+}
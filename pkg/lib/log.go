@@ -0,0 +1,233 @@
+package ghosttocastopod
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// LogConfig configures the logger created by [LogConfig.NewLogger].
+type LogConfig struct {
+	// The minimum level to log: "debug", "info" (the default), "warn" or
+	// "error".
+	Level string `json:"level"`
+	// "text" (the default) or "json".
+	Format string `json:"format"`
+	// How email addresses and tokens are logged: "hash" (the default) replaces
+	// them with a short hash, so that log lines about the same member can
+	// still be correlated, "mask" hides all but their first characters, and
+	// "none" logs them as they are.
+	Redact string `json:"redact"`
+}
+
+const (
+	RedactHash = "hash"
+	RedactMask = "mask"
+	RedactNone = "none"
+)
+
+// The attribute keys that are redacted by the handler from
+// [LogConfig.NewLogger]. Log email addresses and tokens with these keys, as
+// their own attributes.
+const (
+	LogKeyEmail = "email"
+	LogKeyToken = "token"
+)
+
+// level parses Level, defaulting to info.
+func (lc LogConfig) level() (slog.Level, error) {
+	var l slog.Level
+
+	if lc.Level == "" {
+		return slog.LevelInfo, nil
+	}
+
+	err := l.UnmarshalText([]byte(lc.Level))
+	if err != nil {
+		return slog.LevelInfo, fmt.Errorf("unknown level %q", lc.Level)
+	}
+
+	return l, nil
+}
+
+// validate returns an error for every problem with the log config.
+func (lc LogConfig) validate() []error {
+	errs := []error{}
+
+	_, err := lc.level()
+	if err != nil {
+		errs = append(errs, fmt.Errorf("log.level: %v", err))
+	}
+
+	switch lc.Format {
+	case "", "text", "json":
+	default:
+		errs = append(errs, fmt.Errorf("log.format: unknown format %q", lc.Format))
+	}
+
+	switch lc.Redact {
+	case "", RedactHash, RedactMask, RedactNone:
+	default:
+		errs = append(errs, fmt.Errorf("log.redact: unknown mode %q", lc.Redact))
+	}
+
+	return errs
+}
+
+// NewLogger returns a logger that writes to w with the configured level and
+// format, and that redacts every attribute whose key is [LogKeyEmail] or
+// [LogKeyToken].
+func (lc LogConfig) NewLogger(w io.Writer) *slog.Logger {
+	level, _ := lc.level()
+
+	opts := &slog.HandlerOptions{Level: level}
+
+	if lc.Format == "json" {
+		return slog.New(&redactHandler{slog.NewJSONHandler(w, opts), lc.Redact})
+	}
+
+	return slog.New(&redactHandler{slog.NewTextHandler(w, opts), lc.Redact})
+}
+
+// redactHandler redacts the [LogKeyEmail] and [LogKeyToken] attributes of
+// every record, including those in groups, before passing it on.
+type redactHandler struct {
+	slog.Handler
+	mode string
+}
+
+func (h *redactHandler) Handle(ctx context.Context, r slog.Record) error {
+	nr := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+
+	r.Attrs(func(a slog.Attr) bool {
+		nr.AddAttrs(redactAttr(h.mode, a))
+		return true
+	})
+
+	return h.Handler.Handle(ctx, nr)
+}
+
+func (h *redactHandler) WithAttrs(as []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, 0, len(as))
+	for _, a := range as {
+		redacted = append(redacted, redactAttr(h.mode, a))
+	}
+
+	return &redactHandler{h.Handler.WithAttrs(redacted), h.mode}
+}
+
+func (h *redactHandler) WithGroup(name string) slog.Handler {
+	return &redactHandler{h.Handler.WithGroup(name), h.mode}
+}
+
+// redactAttr redacts a according to mode if its key is [LogKeyEmail] or
+// [LogKeyToken], looking inside groups.
+func redactAttr(mode string, a slog.Attr) slog.Attr {
+	a.Value = a.Value.Resolve()
+
+	switch {
+	case a.Value.Kind() == slog.KindGroup:
+		as := a.Value.Group()
+		redacted := make([]any, 0, len(as))
+		for _, ga := range as {
+			redacted = append(redacted, redactAttr(mode, ga))
+		}
+
+		return slog.Group(a.Key, redacted...)
+	case a.Key == LogKeyEmail:
+		return slog.String(a.Key, RedactEmail(mode, a.Value.String()))
+	case a.Key == LogKeyToken:
+		return slog.String(a.Key, RedactToken(mode, a.Value.String()))
+	}
+
+	return a
+}
+
+// hash returns a short, stable hash of s.
+func hash(s string) string {
+	h := sha256.Sum256([]byte(s))
+
+	return "sha256:" + hex.EncodeToString(h[:6])
+}
+
+// RedactEmail redacts an email address according to mode, which is one of
+// [RedactHash] (or empty), [RedactMask] or [RedactNone]. Masking keeps the
+// first character of the local part and of the domain, such as
+// "j***@e***.com".
+func RedactEmail(mode, email string) string {
+	if email == "" {
+		return email
+	}
+
+	switch mode {
+	case RedactNone:
+		return email
+	case RedactMask:
+		local, domain, ok := strings.Cut(email, "@")
+		if !ok {
+			return mask(email)
+		}
+
+		name, tld, ok := strings.Cut(domain, ".")
+		if !ok {
+			return mask(local) + "@" + mask(domain)
+		}
+
+		return mask(local) + "@" + mask(name) + "." + tld
+	default:
+		return hash(strings.ToLower(email))
+	}
+}
+
+// RedactToken redacts a token according to mode, like [RedactEmail]. Masking
+// keeps the first four characters.
+func RedactToken(mode, token string) string {
+	if token == "" {
+		return token
+	}
+
+	switch mode {
+	case RedactNone:
+		return token
+	case RedactMask:
+		if len(token) <= 4 {
+			return "***"
+		}
+
+		return token[:4] + "***"
+	default:
+		return hash(token)
+	}
+}
+
+// mask keeps the first character of s.
+func mask(s string) string {
+	if s == "" {
+		return "***"
+	}
+
+	return string([]rune(s)[:1]) + "***"
+}
+
+// logger returns [Config.Logger]. If it isn't set, it returns [slog.Default]
+// with email addresses and tokens redacted according to [LogConfig.Redact],
+// unless the default logger already redacts them, such as when it came from
+// [LogConfig.NewLogger].
+func (c *Config) logger() *slog.Logger {
+	if c.Logger != nil {
+		return c.Logger
+	}
+
+	l := slog.Default()
+
+	_, ok := l.Handler().(*redactHandler)
+	if ok {
+		return l
+	}
+
+	return slog.New(&redactHandler{l.Handler(), c.Log.Redact})
+}
@@ -0,0 +1,126 @@
+package ghosttocastopod_test
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	ghosttocastopod "github.com/charles-m-knox/ghost-to-castopod/pkg/lib"
+)
+
+func TestRedactEmail(t *testing.T) {
+	t.Parallel()
+
+	const email = "jane.doe@example.com"
+
+	tests := []struct {
+		mode string
+		want string
+	}{
+		{ghosttocastopod.RedactNone, email},
+		{ghosttocastopod.RedactMask, "j***@e***.com"},
+		{ghosttocastopod.RedactHash, ghosttocastopod.RedactEmail(ghosttocastopod.RedactHash, "Jane.Doe@example.com")},
+		{"", ghosttocastopod.RedactEmail(ghosttocastopod.RedactHash, email)},
+	}
+
+	for i, test := range tests {
+		got := ghosttocastopod.RedactEmail(test.mode, email)
+		if got != test.want {
+			t.Errorf("test %v failed: got %v, want %v", i, got, test.want)
+		}
+	}
+
+	if h := ghosttocastopod.RedactEmail(ghosttocastopod.RedactHash, email); !strings.HasPrefix(h, "sha256:") || strings.Contains(h, "jane") {
+		t.Errorf("unexpected hash: %v", h)
+	}
+
+	if ghosttocastopod.RedactToken(ghosttocastopod.RedactMask, "abcdef123456") != "abcd***" {
+		t.Errorf("unexpected token mask: %v", ghosttocastopod.RedactToken(ghosttocastopod.RedactMask, "abcdef123456"))
+	}
+}
+
+func TestLogConfigNewLogger(t *testing.T) {
+	t.Parallel()
+
+	const email = "jane.doe@example.com"
+	const token = "ffbbd29ddf9046a7912320864d1dfcd79d76e50d69ae485dbad895299d11b040"
+
+	c := ghosttocastopod.Config{Log: ghosttocastopod.LogConfig{Format: "json", Level: "warn"}}
+	c.ApplyDefaults()
+
+	var sb strings.Builder
+
+	log := c.Log.NewLogger(&sb)
+	log.Info("hidden", ghosttocastopod.LogKeyEmail, email)
+	log.Warn("visible", ghosttocastopod.LogKeyEmail, email, ghosttocastopod.LogKeyToken, token, "podcast_id", 1)
+
+	out := sb.String()
+	if strings.Contains(out, "hidden") || strings.Contains(out, email) || strings.Contains(out, token) {
+		t.Fatalf("unexpected log output: %v", out)
+	}
+
+	var line map[string]any
+
+	err := json.Unmarshal([]byte(out), &line)
+	if err != nil {
+		t.Fatalf("log output is not json: %v", err)
+	}
+
+	if line[ghosttocastopod.LogKeyEmail] != ghosttocastopod.RedactEmail(ghosttocastopod.RedactHash, email) || line["podcast_id"] != float64(1) {
+		t.Errorf("unexpected log line: %v", line)
+	}
+
+	c.Log = ghosttocastopod.LogConfig{Level: "loud", Format: "xml", Redact: "rot13"}
+
+	err = c.Validate()
+	if err == nil || strings.Count(err.Error(), "\n") != 2 {
+		t.Errorf("expected three validation errors, got %v", err)
+	}
+}
+
+// Tests that use slog.SetDefault can't run in parallel.
+func TestConfigLoggerDefault(t *testing.T) {
+	const email = "jane.doe@example.com"
+	const body = `{"member":{"current":{"email":"jane.doe@example.com"},"previous":{}}}`
+
+	var sb strings.Builder
+
+	def := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&sb, nil)))
+	t.Cleanup(func() { slog.SetDefault(def) })
+
+	// a library user that never sets Logger still gets redacted logs
+	c := &ghosttocastopod.Config{Webhooks: ghosttocastopod.WebhookConfig{Secret: "webhook-secret"}}
+	h := &ghosttocastopod.WebhookHandler{
+		Config: c,
+		Sync:   func(context.Context, string, []ghosttocastopod.GhostMembership) error { return nil },
+	}
+
+	serve := func() {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.Header.Set(ghosttocastopod.GhostSignatureHeader, ghosttocastopod.SignGhostWebhook("webhook-secret", []byte(body), time.Now()))
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	serve()
+
+	out := sb.String()
+	if strings.Contains(out, email) || !strings.Contains(out, ghosttocastopod.RedactEmail(ghosttocastopod.RedactHash, email)) {
+		t.Errorf("unexpected log output: %v", out)
+	}
+
+	// a default logger that already redacts isn't redacted twice
+	sb.Reset()
+	slog.SetDefault(c.Log.NewLogger(&sb))
+	serve()
+
+	out = sb.String()
+	if !strings.Contains(out, ghosttocastopod.RedactEmail(ghosttocastopod.RedactHash, email)) {
+		t.Errorf("unexpected log output: %v", out)
+	}
+}
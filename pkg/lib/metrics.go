@@ -0,0 +1,322 @@
+package ghosttocastopod
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// MetricsConfig configures the optional HTTP listener that exposes [Metrics].
+type MetricsConfig struct {
+	// The address to serve /metrics and /healthz on, such as ":9090". If empty,
+	// nothing is served.
+	Listen string `json:"listen"`
+	// /healthz fails once the last successful sync is older than this. If
+	// zero, the daemon uses three times its effective interval plus jitter,
+	// which may come from its flags rather than [DaemonConfig].
+	MaxAge Duration `json:"maxAge"`
+}
+
+// The stages of a sync that errors are attributed to by [StageError].
+const (
+	StageLock            = "lock"
+	StageReadGhost       = "read_ghost"
+	StageReadCastopod    = "read_castopod"
+	StageSafety          = "safety"
+	StageSnapshot        = "snapshot"
+	StageWrite           = "write"
+	StageAudit           = "audit"
+	StageInvalidateCache = "invalidate_cache"
+	StageNotify          = "notify"
+)
+
+// StageError attributes an error to the stage of a sync that produced it, so
+// that [Metrics] can count errors by stage.
+type StageError struct {
+	Stage string
+	Err   error
+}
+
+func (e StageError) Error() string { return e.Err.Error() }
+func (e StageError) Unwrap() error { return e.Err }
+
+// stages returns the stage of every [StageError] in err's tree, or "unknown"
+// if there are none.
+func stages(err error) []string {
+	var walk func(error) []string
+
+	walk = func(err error) []string {
+		switch e := err.(type) {
+		case StageError:
+			return []string{e.Stage}
+		case interface{ Unwrap() []error }:
+			r := []string{}
+			for _, err := range e.Unwrap() {
+				r = append(r, walk(err)...)
+			}
+
+			return r
+		case interface{ Unwrap() error }:
+			return walk(e.Unwrap())
+		}
+
+		return nil
+	}
+
+	s := walk(err)
+	if len(s) == 0 {
+		return []string{"unknown"}
+	}
+
+	return s
+}
+
+// Subscription changes that are counted by [Metrics].
+const (
+	ActionCreated   = "created"
+	ActionActivated = "activated"
+	ActionSuspended = "suspended"
+)
+
+type subscriptionChange struct {
+	podcastID uint
+	action    string
+}
+
+// Metrics collects statistics about syncs and renders them in the Prometheus
+// text format. It is safe for concurrent use, and every method is a no-op on a
+// nil *Metrics.
+type Metrics struct {
+	mu sync.Mutex
+
+	started      time.Time
+	membersRead  int
+	changes      map[subscriptionChange]int
+	runs         map[bool]int
+	errors       map[string]int
+	lastDuration time.Duration
+	lastSuccess  time.Time
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		started: time.Now(),
+		changes: make(map[subscriptionChange]int),
+		runs:    make(map[bool]int),
+		errors:  make(map[string]int),
+	}
+}
+
+// RecordGhostMembers records how many distinct Ghost members were read by the
+// current sync.
+func (m *Metrics) RecordGhostMembers(gms []GhostMembership) {
+	if m == nil {
+		return
+	}
+
+	emails := make(map[string]bool)
+	for _, gm := range gms {
+		emails[gm.Email] = true
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.membersRead = len(emails)
+}
+
+// wrote returns true if a sync that returned err committed its changes, which
+// is the case when err is nil or only has errors from the stages that run
+// after the write, such as a failure to invalidate the cache.
+func wrote(err error) bool {
+	if err == nil {
+		return true
+	}
+
+	for _, s := range stages(err) {
+		if s != StageAudit && s != StageInvalidateCache && s != StageNotify {
+			return false
+		}
+	}
+
+	return true
+}
+
+// RecordRun records a finished sync that began at start. err is counted
+// against each of its stages (see [StageError]). If the changes in p were
+// written, which is also the case when err only comes from the stages after
+// the write, they are counted as applied and the sync counts as the last
+// success.
+func (m *Metrics) RecordRun(start time.Time, p SyncPlan, err error) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastDuration = time.Since(start)
+	m.runs[err == nil]++
+
+	if err != nil {
+		for _, s := range stages(err) {
+			m.errors[s]++
+		}
+	}
+
+	if !wrote(err) {
+		return
+	}
+
+	m.lastSuccess = time.Now()
+
+	for _, e := range p.Creates {
+		m.changes[subscriptionChange{e.PodcastID, ActionCreated}]++
+	}
+
+	for _, e := range p.StatusChanges {
+		switch {
+		case e.NewStatus == CastopodStatusActive && e.OldStatus != CastopodStatusActive:
+			m.changes[subscriptionChange{e.PodcastID, ActionActivated}]++
+		case e.NewStatus == CastopodStatusSuspended && e.OldStatus != CastopodStatusSuspended:
+			m.changes[subscriptionChange{e.PodcastID, ActionSuspended}]++
+		}
+	}
+}
+
+// LastSuccess returns when the last successful sync finished, or the zero time
+// if there hasn't been one.
+func (m *Metrics) LastSuccess() time.Time {
+	if m == nil {
+		return time.Time{}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.lastSuccess
+}
+
+// WriteTo writes every metric to w in the Prometheus text exposition format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	if m == nil {
+		return 0, nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var n int64
+
+	write := func(format string, a ...any) {
+		c, _ := fmt.Fprintf(w, format, a...)
+		n += int64(c)
+	}
+
+	header := func(name, typ, help string) {
+		write("# HELP %v %v\n# TYPE %v %v\n", name, help, name, typ)
+	}
+
+	header("ghost_to_castopod_ghost_members_read", "gauge", "Distinct Ghost members read by the last sync.")
+	write("ghost_to_castopod_ghost_members_read %v\n", m.membersRead)
+
+	header("ghost_to_castopod_subscriptions_changed_total", "counter", "Castopod subscriptions created, activated or suspended, by podcast.")
+
+	changes := make([]subscriptionChange, 0, len(m.changes))
+	for k := range m.changes {
+		changes = append(changes, k)
+	}
+
+	slices.SortFunc(changes, func(a, b subscriptionChange) int {
+		return cmp.Or(cmp.Compare(a.podcastID, b.podcastID), cmp.Compare(a.action, b.action))
+	})
+
+	for _, k := range changes {
+		write("ghost_to_castopod_subscriptions_changed_total{podcast_id=\"%v\",action=\"%v\"} %v\n", k.podcastID, k.action, m.changes[k])
+	}
+
+	header("ghost_to_castopod_runs_total", "counter", "Finished syncs, by result.")
+	write("ghost_to_castopod_runs_total{result=\"success\"} %v\n", m.runs[true])
+	write("ghost_to_castopod_runs_total{result=\"failure\"} %v\n", m.runs[false])
+
+	header("ghost_to_castopod_errors_total", "counter", "Sync errors, by the stage that failed.")
+
+	stages := make([]string, 0, len(m.errors))
+	for k := range m.errors {
+		stages = append(stages, k)
+	}
+
+	slices.Sort(stages)
+
+	for _, s := range stages {
+		write("ghost_to_castopod_errors_total{stage=\"%v\"} %v\n", s, m.errors[s])
+	}
+
+	header("ghost_to_castopod_run_duration_seconds", "gauge", "How long the last sync took.")
+	write("ghost_to_castopod_run_duration_seconds %v\n", m.lastDuration.Seconds())
+
+	header("ghost_to_castopod_last_success_timestamp_seconds", "gauge", "Unix time of the last sync that wrote its changes, or 0 if there hasn't been one.")
+
+	var last float64
+	if !m.lastSuccess.IsZero() {
+		last = float64(m.lastSuccess.UnixNano()) / float64(time.Second)
+	}
+
+	write("ghost_to_castopod_last_success_timestamp_seconds %v\n", last)
+
+	return n, nil
+}
+
+// Healthy returns an error if the last successful sync finished more than
+// maxAge ago. Before the first sync succeeds, the time that m was created is
+// used instead, so that a freshly started process is given maxAge to succeed.
+func (m *Metrics) Healthy(maxAge time.Duration, now time.Time) error {
+	if m == nil {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	last := m.lastSuccess
+	if last.IsZero() {
+		last = m.started
+	}
+
+	if now.Sub(last) > maxAge {
+		if m.lastSuccess.IsZero() {
+			return fmt.Errorf("no successful sync since starting %v ago", now.Sub(m.started).Round(time.Second))
+		}
+
+		return fmt.Errorf("last successful sync was %v ago", now.Sub(last).Round(time.Second))
+	}
+
+	return nil
+}
+
+// Handler serves the metrics on /metrics and a health check on /healthz,
+// which responds with 503 Service Unavailable when [Metrics.Healthy] fails.
+func (m *Metrics) Handler(maxAge time.Duration) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		m.WriteTo(w)
+	})
+
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		err := m.Healthy(maxAge, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		fmt.Fprintln(w, "ok")
+	})
+
+	return mux
+}
@@ -0,0 +1,113 @@
+package ghosttocastopod_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	ghosttocastopod "github.com/charles-m-knox/ghost-to-castopod/pkg/lib"
+)
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+
+	m := ghosttocastopod.NewMetrics()
+
+	m.RecordGhostMembers([]ghosttocastopod.GhostMembership{
+		{Email: "foo@example.com", PlanID: "a"},
+		{Email: "foo@example.com", TierID: "b"},
+		{Email: "bar@example.com", PlanID: "a"},
+	})
+
+	p := ghosttocastopod.SyncPlan{
+		Creates: []ghosttocastopod.SyncPlanEntry{{PodcastID: 1, NewStatus: cActive}},
+		StatusChanges: []ghosttocastopod.SyncPlanEntry{
+			{PodcastID: 1, OldStatus: cSusp, NewStatus: cActive},
+			{PodcastID: 2, OldStatus: cActive, NewStatus: cSusp},
+			{PodcastID: 2, OldStatus: cActive, NewStatus: cActive, ExpiresAt: "2024-09-01 10:00:00"},
+		},
+	}
+
+	m.RecordRun(time.Now(), p, nil)
+
+	// the changes were committed before the cache and notifications failed,
+	// so they are counted, and the run is the last success
+	err := errors.Join(
+		ghosttocastopod.StageError{Stage: ghosttocastopod.StageInvalidateCache, Err: errors.New("redis is down")},
+		ghosttocastopod.StageError{Stage: ghosttocastopod.StageNotify, Err: errors.New("smtp is down")},
+	)
+	m.RecordRun(time.Now(), p, err)
+
+	if m.LastSuccess().IsZero() {
+		t.Errorf("a run that failed after writing was not recorded as the last success")
+	}
+
+	// nothing was written by these
+	m.RecordRun(time.Now(), p, errors.New("unattributed"))
+	m.RecordRun(time.Now(), p, ghosttocastopod.StageError{Stage: ghosttocastopod.StageWrite, Err: errors.New("rolled back")})
+
+	var sb strings.Builder
+	m.WriteTo(&sb)
+
+	for _, want := range []string{
+		"ghost_to_castopod_ghost_members_read 2\n",
+		`ghost_to_castopod_subscriptions_changed_total{podcast_id="1",action="activated"} 2`,
+		`ghost_to_castopod_subscriptions_changed_total{podcast_id="1",action="created"} 2`,
+		`ghost_to_castopod_subscriptions_changed_total{podcast_id="2",action="suspended"} 2`,
+		`ghost_to_castopod_runs_total{result="success"} 1`,
+		`ghost_to_castopod_runs_total{result="failure"} 3`,
+		`ghost_to_castopod_errors_total{stage="invalidate_cache"} 1`,
+		`ghost_to_castopod_errors_total{stage="notify"} 1`,
+		`ghost_to_castopod_errors_total{stage="unknown"} 1`,
+		`ghost_to_castopod_errors_total{stage="write"} 1`,
+		"# TYPE ghost_to_castopod_last_success_timestamp_seconds gauge",
+	} {
+		if !strings.Contains(sb.String(), want) {
+			t.Errorf("metrics are missing %q:\n%v", want, sb.String())
+		}
+	}
+
+	if strings.Contains(sb.String(), `podcast_id="2",action="activated"`) {
+		t.Errorf("an expiry change was counted as an activation:\n%v", sb.String())
+	}
+}
+
+func TestMetricsHealthz(t *testing.T) {
+	t.Parallel()
+
+	m := ghosttocastopod.NewMetrics()
+
+	// a freshly started process is healthy until maxAge passes without a
+	// successful sync
+	if m.Healthy(time.Minute, time.Now()) != nil || m.Healthy(time.Minute, time.Now().Add(2*time.Minute)) == nil {
+		t.Errorf("unexpected health before the first sync")
+	}
+
+	m.RecordRun(time.Now(), ghosttocastopod.SyncPlan{}, nil)
+
+	if m.Healthy(time.Minute, time.Now().Add(30*time.Second)) != nil || m.Healthy(time.Minute, time.Now().Add(2*time.Minute)) == nil {
+		t.Errorf("unexpected health after a sync")
+	}
+
+	tests := []struct {
+		maxAge time.Duration
+		path   string
+		status int
+	}{
+		{time.Minute, "/healthz", http.StatusOK},
+		{time.Nanosecond, "/healthz", http.StatusServiceUnavailable},
+		{time.Minute, "/metrics", http.StatusOK},
+	}
+
+	for i, test := range tests {
+		rec := httptest.NewRecorder()
+		m.Handler(test.maxAge).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, test.path, nil))
+
+		if rec.Code != test.status {
+			t.Errorf("test %v failed: status mismatch, got %v, want %v", i, rec.Code, test.status)
+		}
+	}
+}
@@ -0,0 +1,452 @@
+package ghosttocastopod
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// NotificationConfig configures the emails that are sent to subscribers
+// whose subscription was created or reactivated, containing a link to their
+// private feed. While notifications are enabled, reactivated subscriptions
+// to a podcast with a template receive a new token, since the plaintext of a
+// stored token can't be recovered.
+type NotificationConfig struct {
+	SMTP SMTPConfig `json:"smtp"`
+	// The email template for each podcast ID. Subscribers to a podcast with no
+	// template are not notified, and keep their token when reactivated.
+	Templates map[uint]NotificationTemplate `json:"templates"`
+	// A JSONL file that notifications which couldn't be sent are appended to,
+	// since their feed links can't be recovered once the subscriptions are
+	// written. They can be sent again with [Config.ResendUndelivered]. If
+	// empty, undelivered notifications are only logged, and their links are
+	// lost. The file contains private feed links, so it is only readable by
+	// its owner.
+	Undelivered string `json:"undelivered"`
+}
+
+// Connection details for sending notification emails. Notifications are only
+// sent if Addr is set.
+type SMTPConfig struct {
+	// The address of the SMTP server, such as "smtp.example.com:587". STARTTLS
+	// is used when the server supports it.
+	Addr string `json:"addr"`
+	// Optional; if set, PLAIN authentication is used, which requires TLS
+	// unless the server is on localhost.
+	Username string `json:"username"`
+	Password string `json:"password" redact:"true"`
+	// The sender address, such as "Podcasts <podcasts@example.com>".
+	From string `json:"from"`
+}
+
+// NotificationTemplate describes the email sent to the subscribers of a
+// single podcast. Subject and Body are [text/template] templates that are
+// executed with a [NotificationData].
+type NotificationTemplate struct {
+	// The podcast's premium feed URL, such as
+	// "https://castopod.example.com/@podcast/feed.xml". The subscriber's token
+	// is added to it as the token query parameter.
+	FeedURL string `json:"feedUrl"`
+	// Defaults to [DefaultNotificationSubject].
+	Subject string `json:"subject"`
+	// Defaults to [DefaultNotificationBody].
+	Body string `json:"body"`
+}
+
+// NotificationData is passed to the templates in [NotificationTemplate].
+type NotificationData struct {
+	Email     string
+	PodcastID uint
+	// The subscriber's private feed URL, including their token.
+	FeedURL string
+	// When the subscription expires; the zero value means never.
+	ExpiresAt time.Time
+}
+
+const DefaultNotificationSubject = "Your private podcast feed"
+
+const DefaultNotificationBody = `Hello,
+
+Your subscription is active. Add this private feed to your podcast app to listen:
+
+{{.FeedURL}}
+
+Please don't share this link; it is unique to you.
+`
+
+// Notification is a rendered email for a single subscription.
+type Notification struct {
+	To        string `json:"to"`
+	PodcastID uint   `json:"podcastId"`
+	Subject   string `json:"subject"`
+	Body      string `json:"body"`
+}
+
+func (n NotificationConfig) enabled() bool {
+	return n.SMTP.Addr != ""
+}
+
+// validate returns an error for every problem with the notification config.
+func (n NotificationConfig) validate() []error {
+	errs := []error{}
+
+	if !n.enabled() {
+		return errs
+	}
+
+	_, err := mail.ParseAddress(n.SMTP.From)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("notifications.smtp.from: invalid address %q: %v", n.SMTP.From, err))
+	}
+
+	for id, t := range n.Templates {
+		_, err = url.Parse(t.FeedURL)
+		if t.FeedURL == "" || err != nil {
+			errs = append(errs, fmt.Errorf("notifications.templates.%v: invalid feedUrl %q", id, t.FeedURL))
+		}
+
+		_, _, err = t.parse()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("notifications.templates.%v: %v", id, err))
+		}
+	}
+
+	return errs
+}
+
+// parse parses the subject and body templates, falling back to the defaults.
+func (t NotificationTemplate) parse() (*template.Template, *template.Template, error) {
+	subject := t.Subject
+	if subject == "" {
+		subject = DefaultNotificationSubject
+	}
+
+	body := t.Body
+	if body == "" {
+		body = DefaultNotificationBody
+	}
+
+	st, err := template.New("subject").Parse(subject)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse subject template: %v", err)
+	}
+
+	bt, err := template.New("body").Parse(body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse body template: %v", err)
+	}
+
+	return st, bt, nil
+}
+
+// render executes the templates for a single subscription.
+func (t NotificationTemplate) render(s CastopodSubscription) (Notification, error) {
+	st, bt, err := t.parse()
+	if err != nil {
+		return Notification{}, err
+	}
+
+	u, err := url.Parse(t.FeedURL)
+	if err != nil {
+		return Notification{}, fmt.Errorf("failed to parse feed url: %v", err)
+	}
+
+	q := u.Query()
+	q.Set("token", s.RawToken)
+	u.RawQuery = q.Encode()
+
+	data := NotificationData{
+		Email:     s.Email,
+		PodcastID: s.PodcastID,
+		FeedURL:   u.String(),
+		ExpiresAt: s.ExpiresAt,
+	}
+
+	var subject, body strings.Builder
+
+	err = st.Execute(&subject, data)
+	if err != nil {
+		return Notification{}, fmt.Errorf("failed to execute subject template: %v", err)
+	}
+
+	err = bt.Execute(&body, data)
+	if err != nil {
+		return Notification{}, fmt.Errorf("failed to execute body template: %v", err)
+	}
+
+	return Notification{
+		To:        s.Email,
+		PodcastID: s.PodcastID,
+		Subject:   subject.String(),
+		Body:      body.String(),
+	}, nil
+}
+
+// GetNotifications renders a [Notification] for every successfully written
+// subscription in results that was created or reactivated and is active,
+// provided its podcast has a template.
+func (c *Config) GetNotifications(results []WriteResult) ([]Notification, error) {
+	ns := []Notification{}
+	errs := []error{}
+
+	for _, r := range results {
+		s := r.Subscription
+		if r.Err != nil || s.RawToken == "" || s.Status != CastopodStatusActive {
+			continue
+		}
+
+		t, ok := c.Notifications.Templates[s.PodcastID]
+		if !ok {
+			continue
+		}
+
+		n, err := t.render(s)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("podcast %v: %v", s.PodcastID, err))
+			continue
+		}
+
+		ns = append(ns, n)
+	}
+
+	return ns, errors.Join(errs...)
+}
+
+// message formats n as an RFC 5322 message.
+func (n Notification) message(from, to *mail.Address) []byte {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "From: %v\r\n", from)
+	fmt.Fprintf(&sb, "To: %v\r\n", to)
+	fmt.Fprintf(&sb, "Subject: %v\r\n", mime.QEncoding.Encode("utf-8", strings.Join(strings.Fields(n.Subject), " ")))
+	fmt.Fprintf(&sb, "Date: %v\r\n", time.Now().Format(time.RFC1123Z))
+	sb.WriteString("MIME-Version: 1.0\r\n")
+	sb.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	sb.WriteString("\r\n")
+
+	body := strings.ReplaceAll(n.Body, "\r\n", "\n")
+	sb.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	return []byte(sb.String())
+}
+
+// SendNotifications sends each notification in ns through the configured
+// SMTP server, one email per notification. Failures don't stop the remaining
+// notifications from being sent; they are joined into the returned error, and
+// the notifications that weren't sent are appended to
+// [NotificationConfig.Undelivered] if it is configured.
+func (c *Config) SendNotifications(ctx context.Context, ns []Notification) (int, error) {
+	sent, failed, err := c.sendNotifications(ctx, ns)
+
+	uerr := c.appendUndelivered(failed)
+	if uerr != nil {
+		return sent, errors.Join(err, uerr)
+	}
+
+	return sent, err
+}
+
+// sendNotifications sends ns like [Config.SendNotifications] and returns the
+// notifications that weren't sent.
+func (c *Config) sendNotifications(ctx context.Context, ns []Notification) (int, []Notification, error) {
+	failed := []Notification{}
+
+	sc := c.Notifications.SMTP
+	if !c.Notifications.enabled() {
+		return 0, failed, nil
+	}
+
+	from, err := mail.ParseAddress(sc.From)
+	if err != nil {
+		return 0, ns, fmt.Errorf("invalid sender %q: %v", sc.From, err)
+	}
+
+	var auth smtp.Auth
+	if sc.Username != "" {
+		host, _, _ := net.SplitHostPort(sc.Addr)
+		auth = smtp.PlainAuth("", sc.Username, sc.Password, host)
+	}
+
+	sent := 0
+	errs := []error{}
+
+	for i, n := range ns {
+		err = ctx.Err()
+		if err != nil {
+			errs = append(errs, err)
+			failed = append(failed, ns[i:]...)
+
+			break
+		}
+
+		to, err := mail.ParseAddress(n.To)
+		if err == nil {
+			err = smtp.SendMail(sc.Addr, auth, from.Address, []string{to.Address}, n.message(from, to))
+		}
+
+		if err != nil {
+			msg := c.redactRecipient(err, n.To)
+			c.logger().Warn("failed to send notification", LogKeyEmail, n.To, "podcast_id", n.PodcastID, "err", msg)
+			errs = append(errs, fmt.Errorf("failed to notify a subscriber about podcast %v: %v", n.PodcastID, msg))
+			failed = append(failed, n)
+
+			continue
+		}
+
+		c.logger().Debug("sent notification", LogKeyEmail, n.To, "podcast_id", n.PodcastID)
+		sent++
+	}
+
+	return sent, failed, errors.Join(errs...)
+}
+
+// redactRecipient returns err's message with every occurrence of the
+// recipient's address redacted according to [LogConfig.Redact], since SMTP
+// servers usually echo it back in their errors, which end up in the logs.
+func (c *Config) redactRecipient(err error, to string) string {
+	addr := to
+
+	a, perr := mail.ParseAddress(to)
+	if perr == nil {
+		addr = a.Address
+	}
+
+	if addr == "" {
+		return err.Error()
+	}
+
+	re := regexp.MustCompile("(?i)" + regexp.QuoteMeta(addr))
+
+	return re.ReplaceAllLiteralString(err.Error(), RedactEmail(c.Log.Redact, addr))
+}
+
+// appendUndelivered appends ns to [NotificationConfig.Undelivered], if it is
+// configured.
+func (c *Config) appendUndelivered(ns []Notification) error {
+	f := c.Notifications.Undelivered
+	if f == "" || len(ns) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+
+	enc := json.NewEncoder(&buf)
+	for _, n := range ns {
+		err := enc.Encode(n)
+		if err != nil {
+			return fmt.Errorf("failed to marshal undelivered notification: %v", err)
+		}
+	}
+
+	fh, err := os.OpenFile(f, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open undelivered notifications %v: %v", f, err)
+	}
+
+	_, err = fh.Write(buf.Bytes())
+	if err != nil {
+		fh.Close()
+		return fmt.Errorf("failed to write undelivered notifications to %v: %v", f, err)
+	}
+
+	err = fh.Close()
+	if err != nil {
+		return fmt.Errorf("failed to close undelivered notifications %v: %v", f, err)
+	}
+
+	c.logger().Warn("saved undelivered notifications", "file", f, "count", len(ns))
+
+	return nil
+}
+
+// ReadNotifications reads the notifications written to
+// [NotificationConfig.Undelivered] from r.
+func ReadNotifications(r io.Reader) ([]Notification, error) {
+	ns := []Notification{}
+
+	d := json.NewDecoder(r)
+	for {
+		var n Notification
+
+		err := d.Decode(&n)
+		if errors.Is(err, io.EOF) {
+			return ns, nil
+		}
+
+		if err != nil {
+			return ns, fmt.Errorf("failed to read notification %v: %v", len(ns)+1, err)
+		}
+
+		ns = append(ns, n)
+	}
+}
+
+// ResendUndelivered sends the notifications in
+// [NotificationConfig.Undelivered] again, and returns how many were sent.
+// While they are sent, the file is moved aside to the same name with a
+// ".resending" suffix, so that the notifications that still can't be sent, as
+// well as any that fail in the meantime, are appended to a new file. The moved
+// file is only removed once that has succeeded; if it is left behind, the next
+// call resends it.
+func (c *Config) ResendUndelivered(ctx context.Context) (int, error) {
+	f := c.Notifications.Undelivered
+	if f == "" {
+		return 0, errors.New("notifications.undelivered is not configured")
+	}
+
+	if !c.Notifications.enabled() {
+		return 0, errors.New("notifications.smtp.addr is not configured")
+	}
+
+	resending := f + ".resending"
+
+	_, err := os.Stat(resending)
+	if errors.Is(err, fs.ErrNotExist) {
+		err = os.Rename(f, resending)
+		if errors.Is(err, fs.ErrNotExist) {
+			return 0, nil
+		}
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to move aside undelivered notifications %v: %v", f, err)
+	}
+
+	b, err := os.ReadFile(resending)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read undelivered notifications %v: %v", resending, err)
+	}
+
+	ns, err := ReadNotifications(bytes.NewReader(b))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read undelivered notifications %v: %v", resending, err)
+	}
+
+	sent, failed, err := c.sendNotifications(ctx, ns)
+
+	uerr := c.appendUndelivered(failed)
+	if uerr != nil {
+		return sent, errors.Join(err, uerr)
+	}
+
+	rerr := os.Remove(resending)
+	if rerr != nil {
+		return sent, errors.Join(err, fmt.Errorf("failed to remove %v: %v", resending, rerr))
+	}
+
+	return sent, err
+}
@@ -0,0 +1,411 @@
+package ghosttocastopod_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	ghosttocastopod "github.com/charles-m-knox/ghost-to-castopod/pkg/lib"
+)
+
+// fakeSMTP is a local SMTP stand-in that accepts every message and records
+// its recipients and data.
+type fakeSMTP struct {
+	mu       sync.Mutex
+	messages []fakeSMTPMessage
+	// reject every recipient, echoing their address like real servers do
+	reject bool
+}
+
+type fakeSMTPMessage struct {
+	from string
+	to   []string
+	data string
+}
+
+func (f *fakeSMTP) listen(t *testing.T) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go f.serve(conn)
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+func (f *fakeSMTP) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	msg := fakeSMTPMessage{}
+
+	fmt.Fprint(conn, "220 localhost ESMTP\r\n")
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			fmt.Fprint(conn, "250 localhost\r\n")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			msg.from = strings.TrimSpace(line)[len("MAIL FROM:"):]
+			fmt.Fprint(conn, "250 OK\r\n")
+		case strings.HasPrefix(cmd, "RCPT TO:") && f.reject:
+			fmt.Fprintf(conn, "550 5.1.1 %v: Recipient address rejected\r\n", strings.TrimSpace(line)[len("RCPT TO:"):])
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			msg.to = append(msg.to, strings.TrimSpace(line)[len("RCPT TO:"):])
+			fmt.Fprint(conn, "250 OK\r\n")
+		case cmd == "DATA":
+			fmt.Fprint(conn, "354 go ahead\r\n")
+
+			var sb strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+
+				if l == ".\r\n" {
+					break
+				}
+
+				sb.WriteString(l)
+			}
+
+			msg.data = sb.String()
+
+			f.mu.Lock()
+			f.messages = append(f.messages, msg)
+			f.mu.Unlock()
+
+			msg = fakeSMTPMessage{}
+			fmt.Fprint(conn, "250 OK\r\n")
+		case cmd == "QUIT":
+			fmt.Fprint(conn, "221 bye\r\n")
+			return
+		default:
+			fmt.Fprint(conn, "250 OK\r\n")
+		}
+	}
+}
+
+func TestNotifications(t *testing.T) {
+	t.Parallel()
+
+	f := &fakeSMTP{}
+
+	c := ghosttocastopod.Config{
+		Plans: map[string][]uint{"plan": {1, 2, 3}},
+		Notifications: ghosttocastopod.NotificationConfig{
+			SMTP: ghosttocastopod.SMTPConfig{
+				Addr: f.listen(t),
+				From: "Podcasts <podcasts@example.com>",
+			},
+			Templates: map[uint]ghosttocastopod.NotificationTemplate{
+				1: {FeedURL: "https://castopod.example.com/@one/feed.xml"},
+				2: {
+					FeedURL: "https://castopod.example.com/@two/feed.xml",
+					Subject: "Welcome to podcast {{.PodcastID}}",
+					Body:    "Hi {{.Email}}, here is your feed: {{.FeedURL}}",
+				},
+			},
+		},
+	}
+	c.ApplyDefaults()
+
+	err := c.Validate()
+	if err != nil {
+		t.Fatalf("unexpected validation err: %v", err)
+	}
+
+	gms := []ghosttocastopod.GhostMembership{
+		{Email: "new@example.com", Status: gActive, PlanID: "plan"},
+		{Email: "back@example.com", Status: gActive, PlanID: "plan"},
+		{Email: "same@example.com", Status: gActive, PlanID: "plan"},
+	}
+
+	cms := []ghosttocastopod.CastopodSubscription{
+		{Email: "back@example.com", PodcastID: 1, Status: cSusp, Token: "oldtoken"},
+		{Email: "back@example.com", PodcastID: 2, Status: cActive, Token: "oldtoken"},
+		{Email: "back@example.com", PodcastID: 3, Status: cSusp, Token: "oldtoken"},
+		{Email: "same@example.com", PodcastID: 1, Status: cActive, Token: "oldtoken"},
+		{Email: "same@example.com", PodcastID: 2, Status: cActive, Token: "oldtoken"},
+		{Email: "same@example.com", PodcastID: 3, Status: cActive, Token: "oldtoken"},
+	}
+
+	results := []ghosttocastopod.WriteResult{}
+	for _, s := range c.GetCastopodSubscriptions(gms, cms) {
+		if s.Changed {
+			results = append(results, ghosttocastopod.WriteResult{Subscription: s, RowsAffected: 1})
+		}
+
+		// podcast 3 has no template, so nobody would be sent a new token and
+		// the existing feed link must keep working
+		if s.Email == "back@example.com" && s.PodcastID == 3 && (s.Status != cActive || s.Token != "oldtoken" || s.RawToken != "") {
+			t.Errorf("subscription without a template was given a new token: %+v", s)
+		}
+	}
+
+	ns, err := c.GetNotifications(results)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	// new@example.com gets podcasts 1 and 2 (3 has no template), and
+	// back@example.com gets podcast 1, which was reactivated (3 has no
+	// template either)
+	if len(ns) != 3 {
+		t.Fatalf("notification count mismatch, got %v, want %v: %+v", len(ns), 3, ns)
+	}
+
+	tokens := map[string]string{}
+	for _, r := range results {
+		tokens[fmt.Sprintf("%v/%v", r.Subscription.Email, r.Subscription.PodcastID)] = r.Subscription.Token
+	}
+
+	for _, n := range ns {
+		i := strings.Index(n.Body, "https://")
+		if i < 0 {
+			t.Fatalf("notification has no feed url: %+v", n)
+		}
+
+		u, err := url.Parse(strings.Fields(n.Body[i:])[0])
+		if err != nil {
+			t.Fatalf("failed to parse feed url: %v", err)
+		}
+
+		// the emailed token must be the plaintext of the token that is stored
+		h := sha256.Sum256([]byte(u.Query().Get("token")))
+		if hex.EncodeToString(h[:]) != tokens[fmt.Sprintf("%v/%v", n.To, n.PodcastID)] {
+			t.Errorf("emailed token does not match the stored hash for %v podcast %v", n.To, n.PodcastID)
+		}
+
+		if n.PodcastID == 2 && (n.Subject != "Welcome to podcast 2" || !strings.HasPrefix(n.Body, "Hi new@example.com")) {
+			t.Errorf("custom template was not used: %+v", n)
+		}
+	}
+
+	sent, err := c.SendNotifications(context.Background(), ns)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	if sent != 3 {
+		t.Errorf("sent count mismatch, got %v, want %v", sent, 3)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.messages) != 3 {
+		t.Fatalf("received message count mismatch, got %v, want %v", len(f.messages), 3)
+	}
+
+	for i, m := range f.messages {
+		if m.from != "<podcasts@example.com>" || len(m.to) != 1 || m.to[0] != fmt.Sprintf("<%v>", ns[i].To) {
+			t.Errorf("message %v envelope mismatch: %+v", i, m)
+		}
+
+		msg, err := mail.ReadMessage(strings.NewReader(m.data))
+		if err != nil {
+			t.Fatalf("message %v failed to parse: %v", i, err)
+		}
+
+		if msg.Header.Get("To") != fmt.Sprintf("<%v>", ns[i].To) || msg.Header.Get("Subject") != ns[i].Subject {
+			t.Errorf("message %v header mismatch: %v", i, msg.Header)
+		}
+	}
+}
+
+func TestNotificationsDisabled(t *testing.T) {
+	t.Parallel()
+
+	c := ghosttocastopod.Config{Plans: map[string][]uint{"plan": {1}}}
+	c.ApplyDefaults()
+
+	gms := []ghosttocastopod.GhostMembership{{Email: "back@example.com", Status: gActive, PlanID: "plan"}}
+	cms := []ghosttocastopod.CastopodSubscription{{Email: "back@example.com", PodcastID: 1, Status: cSusp, Token: "oldtoken"}}
+
+	// tokens are only reissued when notifications are enabled
+	subs := c.GetCastopodSubscriptions(gms, cms)
+	if len(subs) != 1 || subs[0].Status != cActive || subs[0].Token != "oldtoken" {
+		t.Errorf("unexpected subscriptions: %+v", subs)
+	}
+
+	sent, err := c.SendNotifications(context.Background(), []ghosttocastopod.Notification{{To: "back@example.com"}})
+	if err != nil || sent != 0 {
+		t.Errorf("expected a no-op, got %v, %v", sent, err)
+	}
+}
+
+func TestNotificationsBlessedCanceled(t *testing.T) {
+	t.Parallel()
+
+	c := ghosttocastopod.Config{
+		Plans:           map[string][]uint{"plan": {1}},
+		BlessedAccounts: map[string][]uint{"admin@example.com": {1}},
+		Notifications: ghosttocastopod.NotificationConfig{
+			SMTP: ghosttocastopod.SMTPConfig{Addr: "localhost:25", From: "podcasts@example.com"},
+			Templates: map[uint]ghosttocastopod.NotificationTemplate{
+				1: {FeedURL: "https://castopod.example.com/@one/feed.xml"},
+			},
+		},
+	}
+	c.ApplyDefaults()
+
+	gms := []ghosttocastopod.GhostMembership{{Email: "admin@example.com", Status: ghosttocastopod.GhostStatusCanceled, PlanID: "plan"}}
+	cms := []ghosttocastopod.CastopodSubscription{{Email: "admin@example.com", PodcastID: 1, Status: cActive, Token: "oldtoken"}}
+
+	// the blessed account wins over its canceled plan without a change, so
+	// its feed link keeps working and nobody is emailed on every run
+	p := c.GetSyncPlan(gms, cms)
+	if p.HasChanges() {
+		t.Errorf("unexpected changes: %+v", p)
+	}
+
+	subs := p.Subscriptions()
+	if len(subs) != 1 || subs[0].Status != cActive || subs[0].Token != "oldtoken" || subs[0].RawToken != "" || subs[0].Changed {
+		t.Errorf("unexpected subscriptions: %+v", subs)
+	}
+}
+
+func TestNotificationsUndelivered(t *testing.T) {
+	t.Parallel()
+
+	// nothing listens on a closed listener's address
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	addr := l.Addr().String()
+	l.Close()
+
+	undelivered := filepath.Join(t.TempDir(), "undelivered.jsonl")
+
+	c := ghosttocastopod.Config{
+		Notifications: ghosttocastopod.NotificationConfig{
+			SMTP:        ghosttocastopod.SMTPConfig{Addr: addr, From: "podcasts@example.com"},
+			Undelivered: undelivered,
+		},
+	}
+
+	ns := []ghosttocastopod.Notification{
+		{To: "one@example.com", PodcastID: 1, Subject: "feed", Body: "https://castopod.example.com/@one/feed.xml?token=abc"},
+		{To: "two@example.com", PodcastID: 2, Subject: "feed", Body: "https://castopod.example.com/@two/feed.xml?token=def"},
+	}
+
+	sent, err := c.SendNotifications(context.Background(), ns)
+	if err == nil || sent != 0 {
+		t.Fatalf("expected every notification to fail, got %v, %v", sent, err)
+	}
+
+	fi, err := os.Stat(undelivered)
+	if err != nil {
+		t.Fatalf("undelivered notifications were not saved: %v", err)
+	}
+
+	if fi.Mode().Perm() != 0o600 {
+		t.Errorf("undelivered notifications mode mismatch, got %v", fi.Mode().Perm())
+	}
+
+	b, err := os.ReadFile(undelivered)
+	if err != nil {
+		t.Fatalf("failed to read undelivered notifications: %v", err)
+	}
+
+	saved, err := ghosttocastopod.ReadNotifications(bytes.NewReader(b))
+	if err != nil || !slices.Equal(saved, ns) {
+		t.Fatalf("undelivered notifications mismatch, got %+v, %v", saved, err)
+	}
+
+	// once the server is reachable, the saved notifications are sent and the
+	// file is removed
+	f := &fakeSMTP{}
+	c.Notifications.SMTP.Addr = f.listen(t)
+
+	sent, err = c.ResendUndelivered(context.Background())
+	if err != nil || sent != 2 {
+		t.Fatalf("expected both notifications to be resent, got %v, %v", sent, err)
+	}
+
+	f.mu.Lock()
+	if len(f.messages) != 2 || !strings.Contains(f.messages[0].data, "token=abc") {
+		t.Errorf("resent messages mismatch: %+v", f.messages)
+	}
+	f.mu.Unlock()
+
+	for _, name := range []string{undelivered, undelivered + ".resending"} {
+		_, err = os.Stat(name)
+		if !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%v was not removed: %v", filepath.Base(name), err)
+		}
+	}
+
+	// with nothing left to resend, it is a no-op
+	sent, err = c.ResendUndelivered(context.Background())
+	if err != nil || sent != 0 {
+		t.Errorf("expected a no-op, got %v, %v", sent, err)
+	}
+}
+
+func TestNotificationsRedactErrors(t *testing.T) {
+	t.Parallel()
+
+	f := &fakeSMTP{reject: true}
+
+	var buf bytes.Buffer
+
+	c := ghosttocastopod.Config{
+		Notifications: ghosttocastopod.NotificationConfig{
+			SMTP: ghosttocastopod.SMTPConfig{Addr: f.listen(t), From: "podcasts@example.com"},
+		},
+	}
+	c.ApplyDefaults()
+	c.Logger = c.Log.NewLogger(&buf)
+
+	_, err := c.SendNotifications(context.Background(), []ghosttocastopod.Notification{{To: "Secret.Person@example.com", PodcastID: 1}})
+	if err == nil || !strings.Contains(err.Error(), "Recipient address rejected") {
+		t.Fatalf("expected the rejection, got %v", err)
+	}
+
+	// the server echoes the address, in whatever case it likes
+	for name, s := range map[string]string{"err": err.Error(), "log": buf.String()} {
+		if strings.Contains(strings.ToLower(s), "secret.person") {
+			t.Errorf("%v contains the recipient's address: %v", name, s)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package ghosttocastopod
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// SyncPlan is the result of reconciling Ghost memberships against Castopod
+// subscriptions. Every subscription appears in exactly one of Creates,
+// StatusChanges or Unchanged, and every list is sorted by email and then
+// podcast ID so that plans from different runs can be diffed. It can be
+// rendered as text with [SyncPlan.String] or as JSON with [SyncPlan.JSON].
+type SyncPlan struct {
+	// Subscriptions that do not exist in Castopod yet.
+	Creates []SyncPlanEntry `json:"creates"`
+	// Existing subscriptions whose status or expiry will change.
+	StatusChanges []SyncPlanEntry `json:"statusChanges"`
+	// Existing subscriptions that will be left as they are.
+	Unchanged []SyncPlanEntry `json:"unchanged"`
+	// Inputs that were ignored, such as memberships with no email or for a
+	// plan that isn't configured.
+	Skipped []SyncPlanSkip `json:"skipped"`
+}
+
+// SyncPlanEntry describes the planned state of a single subscription.
+type SyncPlanEntry struct {
+	Email     string `json:"email"`
+	PodcastID uint   `json:"podcastId"`
+	// Empty for subscriptions that are being created.
+	OldStatus string `json:"oldStatus,omitempty"`
+	NewStatus string `json:"newStatus"`
+	// When an active subscription expires, in [CastopodTimeFormat]. Empty if it
+	// never expires.
+	ExpiresAt string `json:"expiresAt,omitempty"`
+	// The plan, tier or blessed account that decided NewStatus. See
+	// [CastopodSubscription.Reason].
+	Reason   string `json:"reason"`
+	Orphaned bool   `json:"orphaned,omitempty"`
+
+	// The subscription as it should be written to Castopod. It is omitted from
+	// JSON because it contains the token.
+	Subscription CastopodSubscription `json:"-"`
+	// The subscription as it was read from Castopod, or the zero value for
+	// subscriptions that are being created. See [SyncPlan.Snapshot].
+	Previous CastopodSubscription `json:"-"`
+}
+
+// SyncPlanSkip describes an input that was ignored by the reconciliation.
+type SyncPlanSkip struct {
+	Email     string `json:"email,omitempty"`
+	PlanID    string `json:"planId,omitempty"`
+	TierID    string `json:"tierId,omitempty"`
+	PodcastID uint   `json:"podcastId,omitempty"`
+	Reason    string `json:"reason"`
+}
+
+// Subscriptions returns every subscription in the plan, changed or not, sorted
+// by email and then podcast ID. Pass the result to [WriteCastopodSubscriptions]
+// to apply the plan.
+func (p SyncPlan) Subscriptions() []CastopodSubscription {
+	cs := []CastopodSubscription{}
+
+	for _, entries := range [][]SyncPlanEntry{p.Creates, p.StatusChanges, p.Unchanged} {
+		for _, e := range entries {
+			cs = append(cs, e.Subscription)
+		}
+	}
+
+	slices.SortFunc(cs, func(a, b CastopodSubscription) int {
+		return cmp.Or(cmp.Compare(a.Email, b.Email), cmp.Compare(a.PodcastID, b.PodcastID))
+	})
+
+	return cs
+}
+
+// ForEmail returns the part of the plan that concerns a single email address.
+func (p SyncPlan) ForEmail(email string) SyncPlan {
+	filter := func(entries []SyncPlanEntry) []SyncPlanEntry {
+		r := []SyncPlanEntry{}
+		for _, e := range entries {
+			if e.Email == email {
+				r = append(r, e)
+			}
+		}
+
+		return r
+	}
+
+	f := SyncPlan{
+		Creates:       filter(p.Creates),
+		StatusChanges: filter(p.StatusChanges),
+		Unchanged:     filter(p.Unchanged),
+		Skipped:       []SyncPlanSkip{},
+	}
+
+	for _, s := range p.Skipped {
+		if s.Email == email {
+			f.Skipped = append(f.Skipped, s)
+		}
+	}
+
+	return f
+}
+
+// sort orders every list in the plan by email and then podcast ID.
+func (p *SyncPlan) sort() {
+	for _, entries := range [][]SyncPlanEntry{p.Creates, p.StatusChanges, p.Unchanged} {
+		slices.SortFunc(entries, func(a, b SyncPlanEntry) int {
+			return cmp.Or(cmp.Compare(a.Email, b.Email), cmp.Compare(a.PodcastID, b.PodcastID))
+		})
+	}
+
+	slices.SortFunc(p.Skipped, func(a, b SyncPlanSkip) int {
+		return cmp.Or(
+			cmp.Compare(a.Email, b.Email),
+			cmp.Compare(a.PodcastID, b.PodcastID),
+			cmp.Compare(a.PlanID, b.PlanID),
+			cmp.Compare(a.TierID, b.TierID),
+			cmp.Compare(a.Reason, b.Reason),
+		)
+	})
+}
+
+// formatExpiresAt formats the expiry of an active subscription for a
+// [SyncPlanEntry].
+func formatExpiresAt(s CastopodSubscription) string {
+	if s.Status != CastopodStatusActive || s.ExpiresAt.IsZero() {
+		return ""
+	}
+
+	return s.ExpiresAt.Format(CastopodTimeFormat)
+}
+
+// HasChanges returns true if applying the plan would write anything.
+func (p SyncPlan) HasChanges() bool {
+	return len(p.Creates) > 0 || len(p.StatusChanges) > 0
+}
+
+// JSON renders the plan as indented JSON.
+func (p SyncPlan) JSON() ([]byte, error) {
+	b, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal sync plan: %v", err)
+	}
+
+	return b, nil
+}
+
+// Summary counts the entries in each part of the plan.
+func (p SyncPlan) Summary() string {
+	return fmt.Sprintf("%v to create, %v status changes, %v unchanged, %v skipped", len(p.Creates), len(p.StatusChanges), len(p.Unchanged), len(p.Skipped))
+}
+
+// String renders the plan as human-readable text, one line per subscription.
+func (p SyncPlan) String() string {
+	var sb strings.Builder
+
+	sb.WriteString(p.Summary() + "\n")
+
+	sections := []struct {
+		title   string
+		prefix  string
+		entries []SyncPlanEntry
+	}{
+		{"create", "+", p.Creates},
+		{"status changes", "~", p.StatusChanges},
+		{"unchanged", "=", p.Unchanged},
+	}
+
+	for _, section := range sections {
+		if len(section.entries) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(&sb, "\n%v:\n", section.title)
+
+		for _, e := range section.entries {
+			status := e.NewStatus
+			if e.OldStatus != "" && e.OldStatus != e.NewStatus {
+				status = e.OldStatus + " -> " + e.NewStatus
+			}
+
+			if e.ExpiresAt != "" {
+				status += ", expires " + e.ExpiresAt
+			}
+
+			fmt.Fprintf(&sb, "  %v %v podcast %v: %v (%v)\n", section.prefix, e.Email, e.PodcastID, status, e.Reason)
+		}
+	}
+
+	if len(p.Skipped) > 0 {
+		sb.WriteString("\nskipped:\n")
+
+		for _, s := range p.Skipped {
+			fmt.Fprintf(&sb, "  - %v\n", s)
+		}
+	}
+
+	return sb.String()
+}
+
+// String renders the skipped input as human-readable text.
+func (s SyncPlanSkip) String() string {
+	parts := []string{}
+
+	if s.Email != "" {
+		parts = append(parts, s.Email)
+	}
+
+	if s.PodcastID != 0 {
+		parts = append(parts, fmt.Sprintf("podcast %v", s.PodcastID))
+	}
+
+	if len(parts) == 0 {
+		return s.Reason
+	}
+
+	return strings.Join(parts, " ") + ": " + s.Reason
+}
@@ -0,0 +1,140 @@
+package ghosttocastopod_test
+
+import (
+	"cmp"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+
+	ghosttocastopod "github.com/charles-m-knox/ghost-to-castopod/pkg/lib"
+)
+
+func TestGetSyncPlan(t *testing.T) {
+	t.Parallel()
+
+	const member = "member@example.com"
+	const admin = "admin@example.com"
+	const token = "ffbbd29ddf9046a7912320864d1dfcd79d76e50d69ae485dbad895299d11b040"
+
+	tc := ghosttocastopod.Config{
+		Plans:           map[string][]uint{"plan": {1, 2}},
+		BlessedAccounts: map[string][]uint{admin: {1}},
+	}
+	tc.ApplyDefaults()
+
+	tgm := []ghosttocastopod.GhostMembership{
+		{Email: member, Status: gActive, PlanID: "plan"},
+		{Email: member, Status: gActive, PlanID: "unknown"},
+		{PlanID: "plan"},
+	}
+
+	tcs := []ghosttocastopod.CastopodSubscription{
+		{Email: member, PodcastID: 1, Status: cSusp, Token: token},
+		{Email: admin, PodcastID: 1, Status: cActive},
+	}
+
+	p := tc.GetSyncPlan(tgm, tcs)
+
+	if len(p.Creates) != 1 || p.Creates[0].PodcastID != 2 || p.Creates[0].OldStatus != "" || p.Creates[0].NewStatus != cActive {
+		t.Errorf("unexpected creates: %+v", p.Creates)
+	}
+
+	if len(p.StatusChanges) != 1 || p.StatusChanges[0].OldStatus != cSusp || p.StatusChanges[0].NewStatus != cActive || p.StatusChanges[0].Reason != "plan plan is active" {
+		t.Errorf("unexpected status changes: %+v", p.StatusChanges)
+	}
+
+	if len(p.Unchanged) != 1 || p.Unchanged[0].Email != admin || p.Unchanged[0].Reason != ghosttocastopod.ReasonBlessedAccount {
+		t.Errorf("unexpected unchanged: %+v", p.Unchanged)
+	}
+
+	if len(p.Skipped) != 2 {
+		t.Errorf("unexpected skipped: %+v", p.Skipped)
+	}
+
+	if !p.HasChanges() {
+		t.Errorf("expected the plan to have changes")
+	}
+
+	if len(p.Subscriptions()) != 3 {
+		t.Errorf("subscriptions length mismatch, got %v, want %v", len(p.Subscriptions()), 3)
+	}
+
+	text := p.String()
+	for _, want := range []string{
+		"1 to create, 1 status changes, 1 unchanged, 2 skipped",
+		"~ member@example.com podcast 1: suspended -> active (plan plan is active)",
+		"member@example.com: plan unknown is active, but grants no podcasts",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("text rendering is missing %q:\n%v", want, text)
+		}
+	}
+
+	b, err := p.JSON()
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	if strings.Contains(string(b), token) {
+		t.Errorf("json rendering contains the token: %v", string(b))
+	}
+
+	var got ghosttocastopod.SyncPlan
+	err = json.Unmarshal(b, &got)
+	if err != nil {
+		t.Fatalf("failed to unmarshal rendered plan: %v", err)
+	}
+
+	if got.StatusChanges[0].OldStatus != cSusp || got.Skipped[0].Reason == "" {
+		t.Errorf("json round trip mismatch: %+v", got)
+	}
+}
+
+func TestGetSyncPlanSorted(t *testing.T) {
+	t.Parallel()
+
+	tc := ghosttocastopod.Config{
+		Plans:           map[string][]uint{"a": {3, 1}, "b": {2}},
+		BlessedAccounts: map[string][]uint{"m@example.com": {2, 1}, "a@example.com": {3}},
+	}
+	tc.ApplyDefaults()
+
+	tgm := []ghosttocastopod.GhostMembership{
+		{Email: "z@example.com", Status: gActive, PlanID: "a"},
+		{Email: "b@example.com", Status: gActive, PlanID: "b"},
+		{Email: "b@example.com", Status: gActive, PlanID: "a"},
+		{Email: "y@example.com", Status: gActive, PlanID: "x"},
+		{Email: "c@example.com", Status: gActive, PlanID: "x"},
+	}
+
+	tcs := []ghosttocastopod.CastopodSubscription{
+		{Email: "z@example.com", PodcastID: 3, Status: cActive},
+		{Email: "q@example.com", PodcastID: 1, Status: cActive},
+		{Email: "b@example.com", PodcastID: 2, Status: cActive},
+	}
+
+	// run several times, since map iteration order is random
+	for i := 0; i < 10; i++ {
+		p := tc.GetSyncPlan(tgm, tcs)
+
+		for _, entries := range [][]ghosttocastopod.SyncPlanEntry{p.Creates, p.StatusChanges, p.Unchanged} {
+			if !slices.IsSortedFunc(entries, func(a, b ghosttocastopod.SyncPlanEntry) int {
+				return cmp.Or(cmp.Compare(a.Email, b.Email), cmp.Compare(a.PodcastID, b.PodcastID))
+			}) {
+				t.Fatalf("run %v: entries are not sorted: %+v", i, entries)
+			}
+		}
+
+		if p.Skipped[0].Email != "c@example.com" || p.Skipped[1].Email != "y@example.com" {
+			t.Fatalf("run %v: skipped inputs are not sorted: %+v", i, p.Skipped)
+		}
+
+		subs := tc.GetCastopodSubscriptions(tgm, tcs)
+		if !slices.IsSortedFunc(subs, func(a, b ghosttocastopod.CastopodSubscription) int {
+			return cmp.Or(cmp.Compare(a.Email, b.Email), cmp.Compare(a.PodcastID, b.PodcastID))
+		}) {
+			t.Fatalf("run %v: subscriptions are not sorted: %+v", i, subs)
+		}
+	}
+}
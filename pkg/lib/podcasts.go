@@ -0,0 +1,380 @@
+package ghosttocastopod
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// CASTOPOD_PODCAST_QUERY lists every Castopod podcast, and whether it is
+// premium: either all of its episodes are premium by default, or at least one
+// of them is premium. It is scanned with [GetCastopodPodcast].
+const CASTOPOD_PODCAST_QUERY = `SELECT
+  p.id,
+  p.handle,
+  p.title,
+  p.is_premium_by_default,
+  EXISTS (SELECT 1 FROM cp_episodes AS e WHERE e.podcast_id = p.id AND e.is_premium = 1) AS has_premium_episodes
+FROM cp_podcasts AS p
+`
+
+// CastopodPodcast is a podcast read with [CASTOPOD_PODCAST_QUERY].
+type CastopodPodcast struct {
+	ID uint
+	// Without the leading "@".
+	Handle             string
+	Title              string
+	PremiumByDefault   bool
+	HasPremiumEpisodes bool
+}
+
+// Premium returns true if subscriptions to the podcast grant access to
+// anything.
+func (p CastopodPodcast) Premium() bool {
+	return p.PremiumByDefault || p.HasPremiumEpisodes
+}
+
+func GetCastopodPodcast(rows *sql.Rows) (CastopodPodcast, error) {
+	var p CastopodPodcast
+
+	err := rows.Scan(&p.ID, &p.Handle, &p.Title, &p.PremiumByDefault, &p.HasPremiumEpisodes)
+	if err != nil {
+		return p, fmt.Errorf("failed to scan row: %v", err.Error())
+	}
+
+	return p, nil
+}
+
+// ReadCastopodPodcasts reads every podcast from db using
+// [CASTOPOD_PODCAST_QUERY].
+func ReadCastopodPodcasts(ctx context.Context, db *sql.DB) ([]CastopodPodcast, error) {
+	ps := []CastopodPodcast{}
+
+	rows, err := db.QueryContext(ctx, CASTOPOD_PODCAST_QUERY)
+	if err != nil {
+		return ps, fmt.Errorf("failed to query castopod podcasts: %v", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		p, err := GetCastopodPodcast(rows)
+		if err != nil {
+			return ps, err
+		}
+
+		ps = append(ps, p)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return ps, fmt.Errorf("failed to read castopod podcasts: %v", err)
+	}
+
+	return ps, nil
+}
+
+// podcastRefs is a list of podcasts in the config, where each podcast is
+// either a numeric Castopod ID or a handle such as "@mypodcast".
+type podcastRefs struct {
+	ids     []uint
+	handles []string
+}
+
+func (r *podcastRefs) UnmarshalJSON(b []byte) error {
+	var raw []json.RawMessage
+
+	err := json.Unmarshal(b, &raw)
+	if err != nil {
+		return fmt.Errorf("podcasts must be a list: %v", err)
+	}
+
+	r.ids = []uint{}
+
+	for _, v := range raw {
+		var id uint
+
+		err := json.Unmarshal(v, &id)
+		if err == nil {
+			r.ids = append(r.ids, id)
+			continue
+		}
+
+		var h string
+
+		err = json.Unmarshal(v, &h)
+		if err != nil || len(h) < 2 || !strings.HasPrefix(h, "@") {
+			return fmt.Errorf("podcasts must be numeric IDs or handles such as \"@mypodcast\", got %v", string(v))
+		}
+
+		r.handles = append(r.handles, h)
+	}
+
+	return nil
+}
+
+// splitPodcastRefs separates the IDs from the handles in each entry of refs.
+func splitPodcastRefs(refs map[string]podcastRefs) (map[string][]uint, map[string][]string) {
+	if refs == nil {
+		return nil, nil
+	}
+
+	ids := make(map[string][]uint)
+	handles := make(map[string][]string)
+
+	for k, r := range refs {
+		ids[k] = r.ids
+
+		if len(r.handles) > 0 {
+			handles[k] = r.handles
+		}
+	}
+
+	return ids, handles
+}
+
+// podcastHandles holds the podcast handles from the config until they are
+// resolved by [Config.ResolvePodcastHandles].
+type podcastHandles struct {
+	plans           map[string][]string
+	tiers           map[string][]string
+	blessedAccounts map[string][]string
+}
+
+// UnmarshalJSON allows the podcasts in Plans, Tiers and BlessedAccounts to be
+// given as Castopod handles, such as "@mypodcast", as well as numeric IDs.
+// Handles are kept aside until [Config.ResolvePodcastHandles] adds their IDs.
+func (c *Config) UnmarshalJSON(b []byte) error {
+	// config has Config's fields but not this method, so that decoding into
+	// it doesn't recurse
+	type config Config
+
+	v := struct {
+		*config
+		Plans           map[string]podcastRefs `json:"plans"`
+		Tiers           map[string]podcastRefs `json:"tiers"`
+		BlessedAccounts map[string]podcastRefs `json:"blessedAccounts"`
+	}{config: (*config)(c)}
+
+	err := json.Unmarshal(b, &v)
+	if err != nil {
+		return err
+	}
+
+	c.Plans, c.handles.plans = splitPodcastRefs(v.Plans)
+	c.Tiers, c.handles.tiers = splitPodcastRefs(v.Tiers)
+	c.BlessedAccounts, c.handles.blessedAccounts = splitPodcastRefs(v.BlessedAccounts)
+
+	return nil
+}
+
+// joinPodcastRefs is the reverse of [splitPodcastRefs].
+func joinPodcastRefs(ids map[string][]uint, handles map[string][]string) map[string][]any {
+	if ids == nil && handles == nil {
+		return nil
+	}
+
+	refs := make(map[string][]any)
+
+	for k, v := range ids {
+		refs[k] = []any{}
+		for _, id := range v {
+			refs[k] = append(refs[k], id)
+		}
+	}
+
+	for k, v := range handles {
+		for _, h := range v {
+			refs[k] = append(refs[k], h)
+		}
+	}
+
+	return refs
+}
+
+// MarshalJSON is the reverse of [Config.UnmarshalJSON], so that podcasts that
+// were given by handle and haven't been resolved yet are kept.
+func (c Config) MarshalJSON() ([]byte, error) {
+	type config Config
+
+	return json.Marshal(struct {
+		config
+		Plans           map[string][]any `json:"plans"`
+		Tiers           map[string][]any `json:"tiers"`
+		BlessedAccounts map[string][]any `json:"blessedAccounts"`
+	}{
+		config:          config(c),
+		Plans:           joinPodcastRefs(c.Plans, c.handles.plans),
+		Tiers:           joinPodcastRefs(c.Tiers, c.handles.tiers),
+		BlessedAccounts: joinPodcastRefs(c.BlessedAccounts, c.handles.blessedAccounts),
+	})
+}
+
+// MarshalJSON is the reverse of [CastopodConfig.UnmarshalJSON].
+func (cc CastopodConfig) MarshalJSON() ([]byte, error) {
+	type castopodConfig CastopodConfig
+
+	var managed []any
+	if cc.ManagedPodcasts != nil || cc.managedHandles != nil {
+		managed = []any{}
+	}
+
+	for _, id := range cc.ManagedPodcasts {
+		managed = append(managed, id)
+	}
+
+	for _, h := range cc.managedHandles {
+		managed = append(managed, h)
+	}
+
+	return json.Marshal(struct {
+		castopodConfig
+		ManagedPodcasts []any `json:"managedPodcasts"`
+	}{castopodConfig(cc), managed})
+}
+
+// UnmarshalJSON allows ManagedPodcasts to be given as Castopod handles, like
+// [Config.UnmarshalJSON].
+func (cc *CastopodConfig) UnmarshalJSON(b []byte) error {
+	type castopodConfig CastopodConfig
+
+	v := struct {
+		*castopodConfig
+		ManagedPodcasts *podcastRefs `json:"managedPodcasts"`
+	}{castopodConfig: (*castopodConfig)(cc)}
+
+	err := json.Unmarshal(b, &v)
+	if err != nil {
+		return err
+	}
+
+	cc.ManagedPodcasts = nil
+	cc.managedHandles = nil
+
+	if v.ManagedPodcasts != nil {
+		cc.ManagedPodcasts = v.ManagedPodcasts.ids
+		cc.managedHandles = v.ManagedPodcasts.handles
+	}
+
+	return nil
+}
+
+// PodcastHandles returns every podcast handle in the config, sorted and
+// without duplicates.
+func (c *Config) PodcastHandles() []string {
+	hs := slices.Clone(c.CastopodConfig.managedHandles)
+
+	for _, m := range []map[string][]string{c.handles.plans, c.handles.tiers, c.handles.blessedAccounts} {
+		for _, v := range m {
+			hs = append(hs, v...)
+		}
+	}
+
+	slices.Sort(hs)
+
+	return slices.Compact(hs)
+}
+
+// ResolvePodcastHandles looks up every podcast handle in the config in the
+// cp_podcasts table of db, and adds the podcasts' IDs to Plans, Tiers,
+// BlessedAccounts and [CastopodConfig.ManagedPodcasts]. It returns an error
+// for every handle that doesn't exist and for every podcast that isn't
+// premium, in which case the config is left unchanged. It does nothing if the
+// config has no handles, such as when they have already been resolved.
+//
+// Subscriptions can't be written until the handles are resolved; see
+// [Config.ApplyCastopodSubscriptions].
+func (c *Config) ResolvePodcastHandles(ctx context.Context, db *sql.DB) error {
+	handles := c.PodcastHandles()
+	if len(handles) == 0 {
+		return nil
+	}
+
+	ps, err := ReadCastopodPodcasts(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	ids := make(map[string]uint)
+	errs := []error{}
+
+	for _, h := range handles {
+		i := slices.IndexFunc(ps, func(p CastopodPodcast) bool {
+			return strings.EqualFold(p.Handle, strings.TrimPrefix(h, "@"))
+		})
+
+		switch {
+		case i < 0:
+			errs = append(errs, fmt.Errorf("unknown podcast handle %v", h))
+		case !ps[i].Premium():
+			errs = append(errs, fmt.Errorf("podcast %v (ID %v) is not premium: it has no premium episodes and is not premium by default", h, ps[i].ID))
+		default:
+			ids[h] = ps[i].ID
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	resolve := func(dst map[string][]uint, src map[string][]string) {
+		for k, hs := range src {
+			for _, h := range hs {
+				dst[k] = append(dst[k], ids[h])
+			}
+
+			slices.Sort(dst[k])
+			dst[k] = slices.Compact(dst[k])
+		}
+	}
+
+	if c.Plans == nil {
+		c.Plans = make(map[string][]uint)
+	}
+
+	if c.Tiers == nil {
+		c.Tiers = make(map[string][]uint)
+	}
+
+	if c.BlessedAccounts == nil {
+		c.BlessedAccounts = make(map[string][]uint)
+	}
+
+	resolve(c.Plans, c.handles.plans)
+	resolve(c.Tiers, c.handles.tiers)
+	resolve(c.BlessedAccounts, c.handles.blessedAccounts)
+
+	for _, h := range c.CastopodConfig.managedHandles {
+		if !slices.Contains(c.CastopodConfig.ManagedPodcasts, ids[h]) {
+			c.CastopodConfig.ManagedPodcasts = append(c.CastopodConfig.ManagedPodcasts, ids[h])
+		}
+	}
+
+	// the handles are dropped once their IDs have been added, rather than
+	// being flagged as resolved, so that [Config.MarshalJSON] doesn't write
+	// every resolved podcast twice, once by ID and once by handle
+	c.handles = podcastHandles{}
+	c.CastopodConfig.managedHandles = nil
+
+	return nil
+}
+
+// ErrUnresolvedPodcastHandles is returned when subscriptions are written
+// before [Config.ResolvePodcastHandles] has been called, since the podcasts
+// that were given by handle would otherwise be treated as unconfigured and
+// their subscriptions suspended.
+var ErrUnresolvedPodcastHandles = errors.New("podcast handles in the config have not been resolved")
+
+// checkPodcastHandles returns [ErrUnresolvedPodcastHandles] if the config has
+// handles that haven't been resolved.
+func (c *Config) checkPodcastHandles() error {
+	if len(c.PodcastHandles()) == 0 {
+		return nil
+	}
+
+	return ErrUnresolvedPodcastHandles
+}
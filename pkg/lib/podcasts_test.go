@@ -0,0 +1,144 @@
+package ghosttocastopod_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	ghosttocastopod "github.com/charles-m-knox/ghost-to-castopod/pkg/lib"
+)
+
+func newPodcastsDB() *fakeDB {
+	return &fakeDB{
+		rows: map[string]*fakeRows{
+			"cp_podcasts": {
+				columns: []string{"id", "handle", "title", "is_premium_by_default", "has_premium_episodes"},
+				values: [][]driver.Value{
+					{int64(3), "foo", "Foo", int64(1), int64(0)},
+					{int64(4), "Bar", "Bar", int64(0), int64(1)},
+					{int64(5), "free", "Free", int64(0), int64(0)},
+				},
+			},
+		},
+	}
+}
+
+func TestResolvePodcastHandles(t *testing.T) {
+	t.Parallel()
+
+	const b = `{
+		"plans": {"plan": [1, "@foo"], "other": [2]},
+		"tiers": {"tier": ["@bar", "@foo"]},
+		"blessedAccounts": {"admin@example.com": ["@bar"]},
+		"castopodConfig": {"managedPodcasts": [2, "@foo"], "createdBy": 5}
+	}`
+
+	var c ghosttocastopod.Config
+
+	err := json.Unmarshal([]byte(b), &c)
+	if err != nil {
+		t.Fatalf("failed to unmarshal config: %v", err)
+	}
+
+	c.ApplyDefaults()
+
+	if c.CastopodConfig.CreatedBy != 5 || fmt.Sprint(c.Plans) != "map[other:[2] plan:[1]]" || fmt.Sprint(c.CastopodConfig.ManagedPodcasts) != "[2]" {
+		t.Fatalf("unexpected config before resolving: %+v", c)
+	}
+
+	if got := strings.Join(c.PodcastHandles(), ","); got != "@bar,@foo" {
+		t.Errorf("handles mismatch, got %v", got)
+	}
+
+	_, err = c.ApplyCastopodSubscriptions(context.Background(), (&fakeDB{}).open(), nil)
+	if !errors.Is(err, ghosttocastopod.ErrUnresolvedPodcastHandles) {
+		t.Errorf("expected writing to fail before resolving, got %v", err)
+	}
+
+	db := newPodcastsDB().open()
+	defer db.Close()
+
+	err = c.ResolvePodcastHandles(context.Background(), db)
+	if err != nil {
+		t.Fatalf("failed to resolve handles: %v", err)
+	}
+
+	want := map[string]string{
+		"plans":           "map[other:[2] plan:[1 3]]",
+		"tiers":           "map[tier:[3 4]]",
+		"blessedAccounts": "map[admin@example.com:[4]]",
+		"managedPodcasts": "[2 3]",
+	}
+
+	got := map[string]string{
+		"plans":           fmt.Sprint(c.Plans),
+		"tiers":           fmt.Sprint(c.Tiers),
+		"blessedAccounts": fmt.Sprint(c.BlessedAccounts),
+		"managedPodcasts": fmt.Sprint(c.CastopodConfig.ManagedPodcasts),
+	}
+
+	for k := range want {
+		if got[k] != want[k] {
+			t.Errorf("%v mismatch, got %v, want %v", k, got[k], want[k])
+		}
+	}
+
+	// the resolved handles are dropped, so the marshaled config has only IDs
+	if hs := c.PodcastHandles(); len(hs) != 0 {
+		t.Errorf("handles left after resolving: %v", hs)
+	}
+
+	m, err := json.Marshal(c)
+	if err != nil {
+		t.Fatalf("failed to marshal config: %v", err)
+	}
+
+	if strings.Contains(string(m), "@foo") || !strings.Contains(string(m), `"plan":[1,3]`) {
+		t.Errorf("marshaled config mismatch: %s", m)
+	}
+
+	// resolving again changes nothing
+	err = c.ResolvePodcastHandles(context.Background(), db)
+	if err != nil || fmt.Sprint(c.Plans) != want["plans"] {
+		t.Errorf("resolving twice changed the config: %v %v", c.Plans, err)
+	}
+}
+
+func TestResolvePodcastHandlesErrors(t *testing.T) {
+	t.Parallel()
+
+	var c ghosttocastopod.Config
+
+	err := json.Unmarshal([]byte(`{"plans": {"plan": [1, "@missing", "@free", "@foo"]}}`), &c)
+	if err != nil {
+		t.Fatalf("failed to unmarshal config: %v", err)
+	}
+
+	db := newPodcastsDB().open()
+	defer db.Close()
+
+	err = c.ResolvePodcastHandles(context.Background(), db)
+	if err == nil || !strings.Contains(err.Error(), "unknown podcast handle @missing") || !strings.Contains(err.Error(), "@free (ID 5) is not premium") {
+		t.Errorf("expected both handles to be reported, got %v", err)
+	}
+
+	if fmt.Sprint(c.Plans) != "map[plan:[1]]" {
+		t.Errorf("config should be unchanged after a failure, got %v", c.Plans)
+	}
+
+	for _, b := range []string{
+		`{"plans": {"plan": ["foo"]}}`,
+		`{"plans": {"plan": ["@"]}}`,
+		`{"blessedAccounts": {"admin@example.com": [true]}}`,
+		`{"castopodConfig": {"managedPodcasts": [-1]}}`,
+	} {
+		err := json.Unmarshal([]byte(b), &c)
+		if err == nil {
+			t.Errorf("expected %v to fail to unmarshal", b)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package ghosttocastopod
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ReadGhostMemberships reads every Ghost membership. If [Config.GhostAdminAPI]
+// is configured, the memberships are read with
+// [Config.GetGhostMembershipsFromAdminAPI] and db may be nil. Otherwise, both
+// [GHOST_MEMBERSHIP_QUERY] and [GHOST_TIER_MEMBERSHIP_QUERY] are read from db.
+func (c *Config) ReadGhostMemberships(ctx context.Context, db *sql.DB) ([]GhostMembership, error) {
+	if c.GhostAdminAPI.URL != "" {
+		return c.GetGhostMembershipsFromAdminAPI(ctx, nil)
+	}
+
+	gms := []GhostMembership{}
+
+	queries := []struct {
+		query string
+		scan  func(*sql.Rows) (GhostMembership, error)
+	}{
+		{GHOST_MEMBERSHIP_QUERY, c.GetGhostMembership},
+		{GHOST_TIER_MEMBERSHIP_QUERY, c.GetGhostTierMembership},
+	}
+
+	for _, q := range queries {
+		err := func() error {
+			rows, err := db.QueryContext(ctx, q.query)
+			if err != nil {
+				return fmt.Errorf("failed to query ghost memberships: %v", err)
+			}
+
+			defer rows.Close()
+
+			for rows.Next() {
+				gm, err := q.scan(rows)
+				if err != nil {
+					return err
+				}
+
+				gms = append(gms, gm)
+			}
+
+			err = rows.Err()
+			if err != nil {
+				return fmt.Errorf("failed to read ghost memberships: %v", err)
+			}
+
+			return nil
+		}()
+		if err != nil {
+			return gms, err
+		}
+	}
+
+	return gms, nil
+}
+
+// ReadCastopodSubscriptions reads every Castopod subscription from db using
+// [CASTOPOD_SUBSCRIPTION_QUERY].
+func (c *Config) ReadCastopodSubscriptions(ctx context.Context, db *sql.DB) ([]CastopodSubscription, error) {
+	return c.readCastopodSubscriptions(ctx, db, CASTOPOD_SUBSCRIPTION_QUERY)
+}
+
+// ReadCastopodSubscriptionsByEmail reads the Castopod subscriptions for a
+// single email address from db using [CASTOPOD_SUBSCRIPTION_BY_EMAIL_QUERY].
+func (c *Config) ReadCastopodSubscriptionsByEmail(ctx context.Context, db *sql.DB, email string) ([]CastopodSubscription, error) {
+	return c.readCastopodSubscriptions(ctx, db, CASTOPOD_SUBSCRIPTION_BY_EMAIL_QUERY, email)
+}
+
+func (c *Config) readCastopodSubscriptions(ctx context.Context, db *sql.DB, query string, args ...any) ([]CastopodSubscription, error) {
+	cms := []CastopodSubscription{}
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return cms, fmt.Errorf("failed to query castopod subscriptions: %v", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		s, err := c.GetCastopodSubscription(rows)
+		if err != nil {
+			return cms, err
+		}
+
+		cms = append(cms, s)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return cms, fmt.Errorf("failed to read castopod subscriptions: %v", err)
+	}
+
+	return cms, nil
+}
+
+// CheckGhostDatabase verifies that db is reachable and that the Ghost
+// membership queries can be run against it, without reading every row.
+func CheckGhostDatabase(ctx context.Context, db *sql.DB) error {
+	return checkQueries(ctx, db, "ghost", GHOST_MEMBERSHIP_QUERY, GHOST_TIER_MEMBERSHIP_QUERY)
+}
+
+// CheckCastopodDatabase verifies that db is reachable and that the Castopod
+// subscriptions table can be read, without reading every row.
+func CheckCastopodDatabase(ctx context.Context, db *sql.DB) error {
+	return checkQueries(ctx, db, "castopod", CASTOPOD_SUBSCRIPTION_QUERY)
+}
+
+func checkQueries(ctx context.Context, db *sql.DB, name string, queries ...string) error {
+	err := db.PingContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect to the %v database: %v", name, err)
+	}
+
+	for _, q := range queries {
+		rows, err := db.QueryContext(ctx, strings.TrimSpace(q)+" LIMIT 1")
+		if err != nil {
+			return fmt.Errorf("failed to query the %v database: %v", name, err)
+		}
+
+		rows.Close()
+	}
+
+	return nil
+}
+
+// CheckGhostAdminAPI verifies that the Ghost Admin API is reachable and
+// accepts the configured key by requesting the first page of members. If
+// client is nil, [http.DefaultClient] is used.
+func (c *Config) CheckGhostAdminAPI(ctx context.Context, client *http.Client) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	u, err := url.Parse(strings.TrimSuffix(c.GhostAdminAPI.URL, "/") + ghostAdminAPIMembersPath)
+	if err != nil {
+		return fmt.Errorf("failed to parse ghost admin api url: %v", err)
+	}
+
+	_, err = c.getGhostAdminAPIMembersPage(ctx, client, *u, 1)
+
+	return err
+}
@@ -0,0 +1,117 @@
+package ghosttocastopod_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+
+	ghosttocastopod "github.com/charles-m-knox/ghost-to-castopod/pkg/lib"
+)
+
+func TestReadGhostMemberships(t *testing.T) {
+	t.Parallel()
+
+	f := &fakeDB{
+		rows: map[string]*fakeRows{
+			"FROM members_stripe_customers": {
+				columns: []string{"email", "status", "plan_id", "current_period_end", "cancel_at_period_end", "updated_at"},
+				values: [][]driver.Value{
+					{"foo@example.com", gActive, "plan1", "2024-09-01 10:00:00", false, "2024-08-01 10:00:00"},
+				},
+			},
+			"FROM members as m": {
+				columns: []string{"email", "status", "product_id"},
+				values: [][]driver.Value{
+					{"bar@example.com", ghosttocastopod.GhostMemberStatusComped, "tier1"},
+				},
+			},
+		},
+	}
+	db := f.open()
+	defer db.Close()
+
+	c := ghosttocastopod.Config{}
+	c.ApplyDefaults()
+
+	gms, err := c.ReadGhostMemberships(context.Background(), db)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	if len(gms) != 2 || gms[0].PlanID != "plan1" || gms[0].CurrentPeriodEnd.IsZero() || gms[1].TierID != "tier1" {
+		t.Errorf("unexpected memberships: %+v", gms)
+	}
+
+	err = ghosttocastopod.CheckGhostDatabase(context.Background(), db)
+	if err != nil {
+		t.Errorf("unexpected check err: %v", err)
+	}
+
+	// the castopod table isn't available in this database
+	err = ghosttocastopod.CheckCastopodDatabase(context.Background(), db)
+	if err == nil {
+		t.Errorf("did not receive error but wanted one")
+	}
+}
+
+func TestReadCastopodSubscriptions(t *testing.T) {
+	t.Parallel()
+
+	f := &fakeDB{
+		rows: map[string]*fakeRows{
+			"FROM cp_subscriptions": {
+				columns: []string{"id", "podcast_id", "email", "token", "status", "expires_at", "created_by", "updated_by", "created_at", "updated_at"},
+				values: [][]driver.Value{
+					{int64(1), int64(1), "foo@example.com", "token1", cActive, nil, int64(1), int64(1), "2024-08-01 10:00:00", "2024-08-01 10:00:00"},
+					{int64(2), int64(2), "foo@example.com", "token2", cSusp, "2024-09-01 10:00:00", int64(1), int64(1), "2024-08-01 10:00:00", "2024-08-01 10:00:00"},
+				},
+			},
+		},
+	}
+	db := f.open()
+	defer db.Close()
+
+	c := ghosttocastopod.Config{}
+	c.ApplyDefaults()
+
+	cms, err := c.ReadCastopodSubscriptions(context.Background(), db)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	if len(cms) != 2 || !cms[0].ExpiresAt.IsZero() || cms[1].ExpiresAt.IsZero() || cms[1].Status != cSusp {
+		t.Errorf("unexpected subscriptions: %+v", cms)
+	}
+
+	err = ghosttocastopod.CheckCastopodDatabase(context.Background(), db)
+	if err != nil {
+		t.Errorf("unexpected check err: %v", err)
+	}
+}
+
+func TestSyncPlanForEmail(t *testing.T) {
+	t.Parallel()
+
+	tc := ghosttocastopod.Config{
+		Plans:           map[string][]uint{"plan": {1}},
+		BlessedAccounts: map[string][]uint{"admin@example.com": {1}},
+	}
+	tc.ApplyDefaults()
+
+	tgm := []ghosttocastopod.GhostMembership{
+		{Email: "foo@example.com", Status: gActive, PlanID: "plan"},
+		{Email: "foo@example.com", Status: gActive, PlanID: "unknown"},
+		{Email: "bar@example.com", Status: gActive, PlanID: "plan"},
+	}
+
+	p := tc.GetSyncPlan(tgm, nil).ForEmail("foo@example.com")
+
+	if len(p.Creates) != 1 || p.Creates[0].Email != "foo@example.com" || len(p.Skipped) != 1 {
+		t.Errorf("unexpected plan: %+v", p)
+	}
+
+	if !strings.HasPrefix(p.String(), "1 to create, 0 status changes, 0 unchanged, 1 skipped") {
+		t.Errorf("unexpected rendering: %v", p)
+	}
+}
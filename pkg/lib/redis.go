@@ -0,0 +1,289 @@
+package ghosttocastopod
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Connection details for the Redis server that Castopod uses as its cache.
+// After subscriptions are written, Castopod's cached feeds and subscriptions
+// for the affected podcasts are deleted so that Castopod picks up the changes.
+type RedisConfig struct {
+	// The address of the Redis server, such as "127.0.0.1:6379". If empty,
+	// the cache is not invalidated.
+	Addr string `json:"addr"`
+	// Optional; only needed for Redis ACL users.
+	Username string `json:"username"`
+	Password string `json:"password" redact:"true"`
+	// The Redis database number that Castopod uses.
+	DB int `json:"db"`
+	// Castopod's cache key prefix, if one is configured in Castopod.
+	Prefix string `json:"prefix"`
+	// The cache keys to delete for each affected podcast, as Redis glob
+	// patterns. "{podcastID}" is replaced with the podcast's ID, and Prefix is
+	// prepended. Defaults to [DefaultRedisKeyPatterns].
+	KeyPatterns []string `json:"keyPatterns"`
+	// The timeout for connecting and for each command. Defaults to 5s.
+	Timeout Duration `json:"timeout"`
+}
+
+// DefaultRedisKeyPatterns match the keys that Castopod uses to cache a
+// podcast, its feeds (including each premium subscriber's feed) and its
+// pages.
+var DefaultRedisKeyPatterns = []string{
+	"podcast#{podcastID}",
+	"podcast#{podcastID}_*",
+	"page_podcast#{podcastID}_*",
+}
+
+// The default value of [RedisConfig.Timeout].
+const DefaultRedisTimeout = 5 * time.Second
+
+// The number of keys requested from Redis per SCAN.
+const redisScanCount = 100
+
+// redisError is an error reply from the Redis server.
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// redisConn is a minimal RESP client that supports just enough of the
+// protocol to authenticate, scan and delete keys.
+type redisConn struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	timeout time.Duration
+}
+
+func dialRedis(ctx context.Context, rc RedisConfig) (*redisConn, error) {
+	timeout := time.Duration(rc.Timeout)
+	if timeout <= 0 {
+		timeout = DefaultRedisTimeout
+	}
+
+	d := net.Dialer{Timeout: timeout}
+
+	conn, err := d.DialContext(ctx, "tcp", rc.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to redis: %v", err)
+	}
+
+	c := &redisConn{conn: conn, r: bufio.NewReader(conn), timeout: timeout}
+
+	if rc.Password != "" {
+		args := []string{"AUTH", rc.Password}
+		if rc.Username != "" {
+			args = []string{"AUTH", rc.Username, rc.Password}
+		}
+
+		_, err = c.do(args...)
+		if err != nil {
+			c.Close()
+			return nil, fmt.Errorf("failed to authenticate to redis: %v", err)
+		}
+	}
+
+	if rc.DB != 0 {
+		_, err = c.do("SELECT", strconv.Itoa(rc.DB))
+		if err != nil {
+			c.Close()
+			return nil, fmt.Errorf("failed to select redis db %v: %v", rc.DB, err)
+		}
+	}
+
+	return c, nil
+}
+
+func (c *redisConn) Close() error {
+	return c.conn.Close()
+}
+
+// do sends a command and reads its reply.
+func (c *redisConn) do(args ...string) (any, error) {
+	err := c.conn.SetDeadline(time.Now().Add(c.timeout))
+	if err != nil {
+		return nil, err
+	}
+
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "*%v\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&sb, "$%v\r\n%v\r\n", len(a), a)
+	}
+
+	_, err = io.WriteString(c.conn, sb.String())
+	if err != nil {
+		return nil, err
+	}
+
+	return c.read()
+}
+
+// read parses a single RESP reply. Errors replies are returned as a
+// [redisError], bulk strings as strings (or nil), integers as int64 and arrays
+// as []any.
+func (c *redisConn) read() (any, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+
+		b := make([]byte, n+2)
+		_, err = io.ReadFull(c.r, b)
+		if err != nil {
+			return nil, err
+		}
+
+		return string(b[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+
+		a := make([]any, n)
+		for i := range a {
+			a[i], err = c.read()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return a, nil
+	}
+
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
+
+// scan returns every key matching pattern.
+func (c *redisConn) scan(pattern string) ([]string, error) {
+	keys := []string{}
+	cursor := "0"
+
+	for {
+		reply, err := c.do("SCAN", cursor, "MATCH", pattern, "COUNT", strconv.Itoa(redisScanCount))
+		if err != nil {
+			return keys, err
+		}
+
+		a, ok := reply.([]any)
+		if !ok || len(a) != 2 {
+			return keys, fmt.Errorf("redis: unexpected SCAN reply %v", reply)
+		}
+
+		cursor, ok = a[0].(string)
+		if !ok {
+			return keys, fmt.Errorf("redis: unexpected SCAN cursor %v", a[0])
+		}
+
+		batch, _ := a[1].([]any)
+		for _, k := range batch {
+			s, ok := k.(string)
+			if ok {
+				keys = append(keys, s)
+			}
+		}
+
+		if cursor == "0" {
+			return keys, nil
+		}
+	}
+}
+
+// escapeRedisPattern escapes the glob characters in s so that it only
+// matches itself.
+func escapeRedisPattern(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+	return r.Replace(s)
+}
+
+// AffectedPodcasts returns the sorted, unique podcast IDs of every
+// successfully written subscription in results.
+func AffectedPodcasts(results []WriteResult) []uint {
+	ids := []uint{}
+
+	for _, r := range results {
+		if r.Err == nil && !slices.Contains(ids, r.Subscription.PodcastID) {
+			ids = append(ids, r.Subscription.PodcastID)
+		}
+	}
+
+	slices.Sort(ids)
+
+	return ids
+}
+
+// InvalidateCastopodCache deletes Castopod's cached keys for each podcast in
+// podcastIDs from the Redis server configured in [CastopodConfig.Redis], and
+// returns the number of keys deleted. Unlike FLUSHALL, nothing else on the
+// Redis server is touched.
+func (c *Config) InvalidateCastopodCache(ctx context.Context, podcastIDs []uint) (int, error) {
+	rc := c.CastopodConfig.Redis
+	if rc.Addr == "" || len(podcastIDs) == 0 {
+		return 0, nil
+	}
+
+	patterns := rc.KeyPatterns
+	if len(patterns) == 0 {
+		patterns = DefaultRedisKeyPatterns
+	}
+
+	conn, err := dialRedis(ctx, rc)
+	if err != nil {
+		return 0, err
+	}
+
+	defer conn.Close()
+
+	deleted := 0
+
+	for _, id := range podcastIDs {
+		for _, p := range patterns {
+			pattern := escapeRedisPattern(rc.Prefix) + strings.ReplaceAll(p, "{podcastID}", strconv.FormatUint(uint64(id), 10))
+
+			keys, err := conn.scan(pattern)
+			if err != nil {
+				return deleted, fmt.Errorf("failed to scan redis for %v: %v", pattern, err)
+			}
+
+			for batch := range slices.Chunk(keys, redisScanCount) {
+				reply, err := conn.do(append([]string{"DEL"}, batch...)...)
+				if err != nil {
+					return deleted, fmt.Errorf("failed to delete redis keys for %v: %v", pattern, err)
+				}
+
+				n, _ := reply.(int64)
+				deleted += int(n)
+			}
+		}
+	}
+
+	return deleted, nil
+}
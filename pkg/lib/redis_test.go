@@ -0,0 +1,241 @@
+package ghosttocastopod_test
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	ghosttocastopod "github.com/charles-m-knox/ghost-to-castopod/pkg/lib"
+)
+
+// fakeRedis is a RESP server that supports AUTH, SELECT, SCAN and DEL against
+// an in-memory set of keys. SCAN returns one key per page so that cursors are
+// exercised.
+type fakeRedis struct {
+	mu       sync.Mutex
+	password string
+	keys     map[string]bool
+	commands []string
+}
+
+func (f *fakeRedis) listen(t *testing.T) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go f.serve(conn)
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	authed := f.password == ""
+
+	for {
+		args, err := readRESPCommand(r)
+		if err != nil {
+			return
+		}
+
+		f.mu.Lock()
+		f.commands = append(f.commands, args[0])
+
+		var reply string
+
+		switch {
+		case args[0] == "AUTH":
+			authed = args[len(args)-1] == f.password
+			reply = "+OK\r\n"
+			if !authed {
+				reply = "-WRONGPASS invalid password\r\n"
+			}
+		case !authed:
+			reply = "-NOAUTH Authentication required.\r\n"
+		case args[0] == "SELECT":
+			reply = "+OK\r\n"
+		case args[0] == "SCAN":
+			cursor, _ := strconv.Atoi(args[1])
+			matches := []string{}
+			for k := range f.keys {
+				ok, _ := path.Match(args[3], k)
+				if ok {
+					matches = append(matches, k)
+				}
+			}
+			slices.Sort(matches)
+
+			next, page := "0", []string{}
+			if cursor < len(matches) {
+				page = matches[cursor : cursor+1]
+				if cursor+1 < len(matches) {
+					next = strconv.Itoa(cursor + 1)
+				}
+			}
+
+			reply = fmt.Sprintf("*2\r\n$%v\r\n%v\r\n*%v\r\n", len(next), next, len(page))
+			for _, k := range page {
+				reply += fmt.Sprintf("$%v\r\n%v\r\n", len(k), k)
+			}
+		case args[0] == "DEL":
+			n := 0
+			for _, k := range args[1:] {
+				if f.keys[k] {
+					delete(f.keys, k)
+					n++
+				}
+			}
+			reply = fmt.Sprintf(":%v\r\n", n)
+		default:
+			reply = "-ERR unknown command\r\n"
+		}
+
+		f.mu.Unlock()
+
+		_, err = io.WriteString(conn, reply)
+		if err != nil {
+			return
+		}
+	}
+}
+
+func readRESPCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, n)
+	for i := range args {
+		line, err = r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		l, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "$")))
+		if err != nil {
+			return nil, err
+		}
+
+		b := make([]byte, l+2)
+		_, err = io.ReadFull(r, b)
+		if err != nil {
+			return nil, err
+		}
+
+		args[i] = string(b[:l])
+	}
+
+	return args, nil
+}
+
+func TestInvalidateCastopodCache(t *testing.T) {
+	t.Parallel()
+
+	f := &fakeRedis{
+		password: "secret",
+		keys: map[string]bool{
+			"cp_podcast#1":                      true,
+			"cp_podcast#1_feed":                 true,
+			"cp_podcast#1_feed_abc123":          true,
+			"cp_page_podcast#1_en_public":       true,
+			"cp_podcast#10_feed":                true,
+			"cp_podcast#2_feed":                 true,
+			"podcast#1_feed":                    true,
+			"cp_unrelated":                      true,
+			"other-application:podcast#1_cache": true,
+		},
+	}
+
+	c := ghosttocastopod.Config{}
+	c.CastopodConfig.Redis = ghosttocastopod.RedisConfig{
+		Addr:     f.listen(t),
+		Password: "secret",
+		DB:       2,
+		Prefix:   "cp_",
+	}
+
+	n, err := c.InvalidateCastopodCache(context.Background(), []uint{1})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	if n != 4 {
+		t.Errorf("deleted count mismatch, got %v, want %v", n, 4)
+	}
+
+	for _, k := range []string{"cp_podcast#10_feed", "cp_podcast#2_feed", "podcast#1_feed", "cp_unrelated", "other-application:podcast#1_cache"} {
+		if !f.keys[k] {
+			t.Errorf("key %v should not have been deleted", k)
+		}
+	}
+
+	if len(f.keys) != 5 {
+		t.Errorf("remaining keys mismatch: %v", f.keys)
+	}
+
+	if !slices.Contains(f.commands, "SELECT") || slices.Contains(f.commands, "FLUSHALL") {
+		t.Errorf("unexpected commands: %v", f.commands)
+	}
+
+	// a wrong password must surface as an error
+	c.CastopodConfig.Redis.Password = "wrong"
+
+	_, err = c.InvalidateCastopodCache(context.Background(), []uint{1})
+	if err == nil {
+		t.Errorf("did not receive error but wanted one")
+	}
+
+	// no address means invalidation is disabled
+	c.CastopodConfig.Redis.Addr = ""
+
+	n, err = c.InvalidateCastopodCache(context.Background(), []uint{1})
+	if err != nil || n != 0 {
+		t.Errorf("expected a no-op, got %v, %v", n, err)
+	}
+}
+
+func TestAffectedPodcasts(t *testing.T) {
+	t.Parallel()
+
+	results := []ghosttocastopod.WriteResult{
+		{Subscription: ghosttocastopod.CastopodSubscription{PodcastID: 3}},
+		{Subscription: ghosttocastopod.CastopodSubscription{PodcastID: 1}},
+		{Subscription: ghosttocastopod.CastopodSubscription{PodcastID: 3}},
+		{Subscription: ghosttocastopod.CastopodSubscription{PodcastID: 2}, Err: fmt.Errorf("failed")},
+	}
+
+	got := ghosttocastopod.AffectedPodcasts(results)
+	if !slices.Equal(got, []uint{1, 3}) {
+		t.Errorf("affected podcasts mismatch, got %v, want %v", got, []uint{1, 3})
+	}
+}
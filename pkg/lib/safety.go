@@ -0,0 +1,270 @@
+package ghosttocastopod
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SafetyConfig limits how many subscriptions a single sync may suspend, so
+// that a transient problem on the Ghost side, such as its Stripe tables being
+// briefly empty during a migration, doesn't suspend every paying subscriber.
+// See [Config.CheckSuspensions].
+type SafetyConfig struct {
+	// Refuse a sync that would suspend more than this many currently active
+	// subscriptions. 0 means no limit.
+	MaxSuspensions int `json:"maxSuspensions"`
+	// Refuse a sync that would suspend more than this percentage of the
+	// currently active subscriptions, such as 10 for 10%. 0 means no limit.
+	MaxSuspensionPercent float64 `json:"maxSuspensionPercent"`
+	// The directory that refused plans and pre-write snapshots are written
+	// to. Defaults to the current directory.
+	Dir string `json:"dir"`
+	// How many snapshots, and separately how many refused plans, to keep in
+	// Dir. Whenever one is written, the oldest beyond this many are removed.
+	// 0 keeps all of them.
+	Keep int `json:"keep"`
+}
+
+// The file name prefixes of the files written to [SafetyConfig.Dir].
+const (
+	SnapshotPrefix    = "snapshot-"
+	RefusedPlanPrefix = "refused-plan-"
+)
+
+// safetyFileTimeFormat is the time in the names of the files written to
+// [SafetyConfig.Dir].
+const safetyFileTimeFormat = "20060102T150405Z"
+
+// ErrTooManySuspensions is returned by [Config.CheckSuspensions] when a plan
+// exceeds the limits in [SafetyConfig].
+var ErrTooManySuspensions = errors.New("too many suspensions")
+
+// Suspensions returns how many currently active subscriptions the plan
+// suspends, and how many subscriptions are currently active.
+func (p SyncPlan) Suspensions() (suspended, active int) {
+	for _, e := range p.StatusChanges {
+		if e.OldStatus != CastopodStatusActive {
+			continue
+		}
+
+		active++
+
+		if e.NewStatus == CastopodStatusSuspended {
+			suspended++
+		}
+	}
+
+	for _, e := range p.Unchanged {
+		if e.OldStatus == CastopodStatusActive {
+			active++
+		}
+	}
+
+	return suspended, active
+}
+
+// CheckSuspensions returns an error wrapping [ErrTooManySuspensions] if the
+// plan suspends more active subscriptions than [SafetyConfig] allows.
+func (c *Config) CheckSuspensions(p SyncPlan) error {
+	suspended, active := p.Suspensions()
+
+	if c.Safety.MaxSuspensions > 0 && suspended > c.Safety.MaxSuspensions {
+		return fmt.Errorf("%w: %v of %v active subscriptions would be suspended, more than safety.maxSuspensions (%v)", ErrTooManySuspensions, suspended, active, c.Safety.MaxSuspensions)
+	}
+
+	if c.Safety.MaxSuspensionPercent > 0 && active > 0 {
+		percent := float64(suspended) * 100 / float64(active)
+		if percent > c.Safety.MaxSuspensionPercent {
+			return fmt.Errorf("%w: %v of %v active subscriptions (%.1f%%) would be suspended, more than safety.maxSuspensionPercent (%v%%)", ErrTooManySuspensions, suspended, active, percent, c.Safety.MaxSuspensionPercent)
+		}
+	}
+
+	return nil
+}
+
+// validate returns an error for every problem with the safety config.
+func (sc SafetyConfig) validate() []error {
+	errs := []error{}
+
+	if sc.MaxSuspensions < 0 {
+		errs = append(errs, fmt.Errorf("safety.maxSuspensions cannot be negative"))
+	}
+
+	if sc.MaxSuspensionPercent < 0 || sc.MaxSuspensionPercent > 100 {
+		errs = append(errs, fmt.Errorf("safety.maxSuspensionPercent must be between 0 and 100"))
+	}
+
+	if sc.Keep < 0 {
+		errs = append(errs, fmt.Errorf("safety.keep cannot be negative"))
+	}
+
+	return errs
+}
+
+// writeNewFile writes b to a new file named name in dir, which is created if
+// needed, and returns the file's path. If the file already exists, such as
+// when two are written within the same second, a counter is added before the
+// extension, as in "snapshot-20240102T030405Z-2.json"; an existing file is
+// never overwritten. The file is only readable by its owner.
+func writeNewFile(dir, name string, b []byte) (string, error) {
+	if dir == "" {
+		dir = "."
+	}
+
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return "", fmt.Errorf("failed to create %v: %v", dir, err)
+	}
+
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+
+	for i := 1; ; i++ {
+		f := filepath.Join(dir, name)
+		if i > 1 {
+			f = filepath.Join(dir, fmt.Sprintf("%v-%v%v", base, i, ext))
+		}
+
+		fh, err := os.OpenFile(f, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+
+		if err != nil {
+			return "", fmt.Errorf("failed to create %v: %v", f, err)
+		}
+
+		_, err = fh.Write(b)
+		if err != nil {
+			fh.Close()
+			return "", fmt.Errorf("failed to write %v: %v", f, err)
+		}
+
+		err = fh.Close()
+		if err != nil {
+			return "", fmt.Errorf("failed to write %v: %v", f, err)
+		}
+
+		return f, nil
+	}
+}
+
+// pruneSafetyFiles removes the oldest files in [SafetyConfig.Dir] whose names
+// start with prefix, so that only [SafetyConfig.Keep] of them remain. Files
+// are ordered by the time in their name, and then by the counter that
+// [writeNewFile] adds to the names of files from the same second.
+func (c *Config) pruneSafetyFiles(prefix string) error {
+	if c.Safety.Keep <= 0 {
+		return nil
+	}
+
+	dir := c.Safety.Dir
+	if dir == "" {
+		dir = "."
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to list %v: %v", dir, err)
+	}
+
+	type file struct {
+		name    string
+		time    string
+		counter int
+	}
+
+	files := []file{}
+
+	for _, e := range entries {
+		rest, ok := strings.CutPrefix(e.Name(), prefix)
+		if !ok || !e.Type().IsRegular() || !strings.HasSuffix(rest, ".json") {
+			continue
+		}
+
+		// "20240102T030405Z-2.json" has the time 20240102T030405Z and the
+		// counter 2, and the first file from that second has the counter 1
+		t, n, _ := strings.Cut(strings.TrimSuffix(rest, ".json"), "-")
+
+		counter := 1
+		if n != "" {
+			counter, err = strconv.Atoi(n)
+			if err != nil {
+				continue
+			}
+		}
+
+		files = append(files, file{e.Name(), t, counter})
+	}
+
+	if len(files) <= c.Safety.Keep {
+		return nil
+	}
+
+	slices.SortFunc(files, func(a, b file) int {
+		return cmp.Or(cmp.Compare(a.time, b.time), cmp.Compare(a.counter, b.counter))
+	})
+
+	errs := []error{}
+
+	for _, f := range files[:len(files)-c.Safety.Keep] {
+		err := os.Remove(filepath.Join(dir, f.name))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		c.logger().Debug("removed old file", "file", f.name)
+	}
+
+	return errors.Join(errs...)
+}
+
+// WriteSnapshot writes s to [SafetyConfig.Dir] using [Snapshot.WriteFile],
+// then removes the oldest snapshots beyond [SafetyConfig.Keep]. Failing to
+// remove them is only logged.
+func (c *Config) WriteSnapshot(s Snapshot) (string, error) {
+	f, err := s.WriteFile(c.Safety.Dir)
+	if err != nil {
+		return "", err
+	}
+
+	err = c.pruneSafetyFiles(SnapshotPrefix)
+	if err != nil {
+		c.logger().Warn("failed to remove old snapshots", "err", err)
+	}
+
+	return f, nil
+}
+
+// WriteRefusedPlan writes a plan that [Config.CheckSuspensions] refused to a
+// new file in [SafetyConfig.Dir] for review, named after now like
+// [Snapshot.Filename], and returns the file's path. It then removes the oldest
+// refused plans beyond [SafetyConfig.Keep]. The file is only readable by its
+// owner, since it contains email addresses.
+func (c *Config) WriteRefusedPlan(p SyncPlan, now time.Time) (string, error) {
+	b, err := p.JSON()
+	if err != nil {
+		return "", err
+	}
+
+	f, err := writeNewFile(c.Safety.Dir, RefusedPlanPrefix+now.UTC().Format(safetyFileTimeFormat)+".json", b)
+	if err != nil {
+		return "", fmt.Errorf("failed to write refused plan: %v", err)
+	}
+
+	err = c.pruneSafetyFiles(RefusedPlanPrefix)
+	if err != nil {
+		c.logger().Warn("failed to remove old refused plans", "err", err)
+	}
+
+	return f, nil
+}
@@ -0,0 +1,137 @@
+package ghosttocastopod_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	ghosttocastopod "github.com/charles-m-knox/ghost-to-castopod/pkg/lib"
+)
+
+func TestCheckSuspensions(t *testing.T) {
+	t.Parallel()
+
+	// 2 of 4 active subscriptions are suspended; the suspended one that stays
+	// suspended and the one being reactivated don't count
+	p := ghosttocastopod.SyncPlan{
+		Creates: []ghosttocastopod.SyncPlanEntry{
+			{Email: "new@example.com", NewStatus: cActive},
+		},
+		StatusChanges: []ghosttocastopod.SyncPlanEntry{
+			{Email: "a@example.com", OldStatus: cActive, NewStatus: cSusp},
+			{Email: "b@example.com", OldStatus: cActive, NewStatus: cSusp},
+			{Email: "c@example.com", OldStatus: cSusp, NewStatus: cActive},
+			{Email: "d@example.com", OldStatus: cActive, NewStatus: cActive},
+		},
+		Unchanged: []ghosttocastopod.SyncPlanEntry{
+			{Email: "e@example.com", OldStatus: cActive, NewStatus: cActive},
+			{Email: "f@example.com", OldStatus: cSusp, NewStatus: cSusp},
+		},
+	}
+
+	suspended, active := p.Suspensions()
+	if suspended != 2 || active != 4 {
+		t.Fatalf("suspensions mismatch, got %v of %v, want 2 of 4", suspended, active)
+	}
+
+	tests := []struct {
+		safety  ghosttocastopod.SafetyConfig
+		refused bool
+	}{
+		{ghosttocastopod.SafetyConfig{}, false},
+		{ghosttocastopod.SafetyConfig{MaxSuspensions: 2}, false},
+		{ghosttocastopod.SafetyConfig{MaxSuspensions: 1}, true},
+		{ghosttocastopod.SafetyConfig{MaxSuspensionPercent: 50}, false},
+		{ghosttocastopod.SafetyConfig{MaxSuspensionPercent: 49.9}, true},
+		{ghosttocastopod.SafetyConfig{MaxSuspensions: 10, MaxSuspensionPercent: 25}, true},
+	}
+
+	for i, test := range tests {
+		c := ghosttocastopod.Config{Safety: test.safety}
+
+		err := c.CheckSuspensions(p)
+		if errors.Is(err, ghosttocastopod.ErrTooManySuspensions) != test.refused {
+			t.Errorf("test %v failed: refused mismatch, got err %v", i, err)
+		}
+	}
+
+	c := ghosttocastopod.Config{Safety: ghosttocastopod.SafetyConfig{MaxSuspensions: 1}}
+
+	err := c.CheckSuspensions(ghosttocastopod.SyncPlan{})
+	if err != nil {
+		t.Errorf("an empty plan should never be refused, got %v", err)
+	}
+
+	c.Safety.MaxSuspensionPercent = 101
+
+	err = c.Validate()
+	if err == nil {
+		t.Errorf("expected a percentage over 100 to be invalid")
+	}
+}
+
+func TestSafetyFiles(t *testing.T) {
+	t.Parallel()
+
+	dir := filepath.Join(t.TempDir(), "safety")
+
+	c := ghosttocastopod.Config{Safety: ghosttocastopod.SafetyConfig{Dir: dir, Keep: 2}}
+	c.ApplyDefaults()
+
+	// every snapshot is taken within the same second, so they would all have
+	// the same name
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	s := ghosttocastopod.Snapshot{TakenAt: now}
+
+	written := []string{}
+
+	for range 3 {
+		f, err := c.WriteSnapshot(s)
+		if err != nil {
+			t.Fatalf("failed to write snapshot: %v", err)
+		}
+
+		written = append(written, filepath.Base(f))
+	}
+
+	want := []string{"snapshot-20240102T030405Z.json", "snapshot-20240102T030405Z-2.json", "snapshot-20240102T030405Z-3.json"}
+	if !slices.Equal(written, want) {
+		t.Errorf("snapshot names mismatch, got %v, want %v", written, want)
+	}
+
+	for range 3 {
+		f, err := c.WriteRefusedPlan(ghosttocastopod.SyncPlan{}, now)
+		if err != nil {
+			t.Fatalf("failed to write refused plan: %v", err)
+		}
+
+		fi, err := os.Stat(f)
+		if err != nil || fi.Mode().Perm() != 0o600 {
+			t.Errorf("refused plan should only be readable by its owner: %v %v", fi, err)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to list dir: %v", err)
+	}
+
+	// only the newest two of each are kept
+	remaining := map[string][]string{}
+	for _, e := range entries {
+		prefix, _, _ := strings.Cut(e.Name(), "2024")
+		remaining[prefix] = append(remaining[prefix], e.Name())
+	}
+
+	if len(remaining) != 2 || len(remaining[ghosttocastopod.SnapshotPrefix]) != 2 || len(remaining[ghosttocastopod.RefusedPlanPrefix]) != 2 {
+		t.Errorf("unexpected files remain: %v", remaining)
+	}
+
+	if slices.Contains(remaining[ghosttocastopod.SnapshotPrefix], want[0]) {
+		t.Errorf("the oldest snapshot was kept: %v", remaining)
+	}
+}
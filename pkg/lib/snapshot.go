@@ -0,0 +1,235 @@
+package ghosttocastopod
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// CASTOPOD_SUBSCRIPTION_STATUS_QUERY reads a subscription's current status,
+// locking its row, before [Config.RestoreSnapshot] restores it, so that the
+// audit log records what the rollback changed.
+const CASTOPOD_SUBSCRIPTION_STATUS_QUERY = `SELECT status FROM cp_subscriptions WHERE podcast_id = ? AND email = ? FOR UPDATE`
+
+// CASTOPOD_SUBSCRIPTION_RESTORE is the prepared statement used by
+// [Config.RestoreSnapshot]. Rows are matched on podcast_id and email, like
+// [CASTOPOD_SUBSCRIPTION_UPSERT], since subscriptions created by a sync have
+// no known id.
+const CASTOPOD_SUBSCRIPTION_RESTORE = `UPDATE cp_subscriptions
+SET token = ?, status = ?, expires_at = ?, updated_by = ?, updated_at = ?
+WHERE podcast_id = ? AND email = ?`
+
+// Snapshot records the state of every Castopod subscription that a sync is
+// about to modify, so that the sync can be undone with
+// [Config.RestoreSnapshot]. Take one with [SyncPlan.Snapshot] before applying
+// the plan.
+type Snapshot struct {
+	TakenAt time.Time `json:"takenAt"`
+	// Existing subscriptions, as they were before the sync.
+	Rows []SnapshotRow `json:"rows"`
+	// Subscriptions that the sync creates. They did not exist before, so
+	// restoring the snapshot suspends them.
+	Created []SnapshotRow `json:"created"`
+}
+
+// SnapshotRow is a single cp_subscriptions row in a [Snapshot]. Times are in
+// [CastopodTimeFormat].
+type SnapshotRow struct {
+	ID        uint   `json:"id,omitempty"`
+	PodcastID uint   `json:"podcastId"`
+	Email     string `json:"email"`
+	// The sha256 hash of the subscriber's token, as Castopod stores it.
+	Token     string `json:"token"`
+	Status    string `json:"status"`
+	ExpiresAt string `json:"expiresAt,omitempty"`
+	UpdatedBy uint   `json:"updatedBy"`
+	UpdatedAt string `json:"updatedAt"`
+}
+
+func newSnapshotRow(s CastopodSubscription) SnapshotRow {
+	r := SnapshotRow{
+		ID:        s.ID,
+		PodcastID: s.PodcastID,
+		Email:     s.Email,
+		Token:     s.Token,
+		Status:    s.Status,
+		UpdatedBy: s.UpdatedBy,
+		UpdatedAt: s.UpdatedAt.Format(CastopodTimeFormat),
+	}
+
+	if !s.ExpiresAt.IsZero() {
+		r.ExpiresAt = s.ExpiresAt.Format(CastopodTimeFormat)
+	}
+
+	return r
+}
+
+// Snapshot returns the state of every subscription that applying the plan
+// would modify, as it was when the plan was made.
+func (p SyncPlan) Snapshot(now time.Time) Snapshot {
+	s := Snapshot{
+		TakenAt: now.UTC(),
+		Rows:    []SnapshotRow{},
+		Created: []SnapshotRow{},
+	}
+
+	for _, e := range p.StatusChanges {
+		s.Rows = append(s.Rows, newSnapshotRow(e.Previous))
+	}
+
+	for _, e := range p.Creates {
+		s.Created = append(s.Created, newSnapshotRow(e.Subscription))
+	}
+
+	return s
+}
+
+// Filename returns a name for the snapshot's file that sorts by when it was
+// taken, such as "snapshot-20240102T030405Z.json".
+func (s Snapshot) Filename() string {
+	return SnapshotPrefix + s.TakenAt.UTC().Format(safetyFileTimeFormat) + ".json"
+}
+
+// WriteFile writes the snapshot as json to a new file in dir, which is
+// created if needed, and returns the file's path. The file is named
+// [Snapshot.Filename], with a counter added if that already exists, so that an
+// earlier snapshot is never overwritten. The file is only readable by its
+// owner, since it contains token hashes. See [Config.WriteSnapshot], which
+// also removes old snapshots.
+func (s Snapshot) WriteFile(dir string) (string, error) {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal snapshot: %v", err)
+	}
+
+	f, err := writeNewFile(dir, s.Filename(), b)
+	if err != nil {
+		return "", fmt.Errorf("failed to write snapshot: %v", err)
+	}
+
+	return f, nil
+}
+
+// LoadSnapshot reads a snapshot that was written by [Snapshot.WriteFile].
+func LoadSnapshot(f string) (Snapshot, error) {
+	b, err := os.ReadFile(f)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("failed to load snapshot from %v: %v", f, err)
+	}
+
+	var s Snapshot
+	err = json.Unmarshal(b, &s)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("failed to unmarshal snapshot from %v: %v", f, err)
+	}
+
+	return s, nil
+}
+
+// Args returns the placeholder arguments for [CASTOPOD_SUBSCRIPTION_RESTORE],
+// in order.
+func (r SnapshotRow) Args() []any {
+	var expiresAt any
+	if r.ExpiresAt != "" {
+		expiresAt = r.ExpiresAt
+	}
+
+	return []any{r.Token, r.Status, expiresAt, r.UpdatedBy, r.UpdatedAt, r.PodcastID, r.Email}
+}
+
+// RestoreSnapshot undoes the sync that s was taken before: every row in
+// s.Rows gets back its token, status, expiry and updated_* fields, and every
+// row in s.Created is suspended, with UpdatedBy from the config. All rows are
+// written inside one transaction; if any row fails, nothing is restored.
+//
+// It returns a result for every restored subscription, whose podcasts can be
+// passed to [Config.InvalidateCastopodCache] using [AffectedPodcasts]. Once
+// the rows are restored, they are recorded in the audit log like any other
+// change, with the cause "rollback <name>", where name is the snapshot's file
+// name; failing to record them returns a [StageError] for [StageAudit] along
+// with the results.
+func (c *Config) RestoreSnapshot(ctx context.Context, db *sql.DB, s Snapshot, name string) ([]WriteResult, error) {
+	results := []WriteResult{}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return results, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+
+	// Rollback is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, CASTOPOD_SUBSCRIPTION_RESTORE)
+	if err != nil {
+		return results, fmt.Errorf("failed to prepare restore statement: %v", err)
+	}
+
+	defer stmt.Close()
+
+	rows := append([]SnapshotRow{}, s.Rows...)
+	now := time.Now()
+
+	for _, r := range s.Created {
+		r.Status = CastopodStatusSuspended
+		r.ExpiresAt = ""
+		r.UpdatedBy = c.CastopodConfig.UpdatedBy
+		r.UpdatedAt = now.Format(CastopodTimeFormat)
+		rows = append(rows, r)
+	}
+
+	for _, r := range rows {
+		// the status that the sync left behind, for the audit log
+		var old string
+
+		err := tx.QueryRowContext(ctx, CASTOPOD_SUBSCRIPTION_STATUS_QUERY, r.PodcastID, r.Email).Scan(&old)
+		if errors.Is(err, sql.ErrNoRows) {
+			// the row has been deleted since, so there is nothing to restore
+			continue
+		}
+
+		if err != nil {
+			return []WriteResult{}, fmt.Errorf("failed to read subscription for podcast %v, rolled back: %v", r.PodcastID, err)
+		}
+
+		res, err := stmt.ExecContext(ctx, r.Args()...)
+		if err != nil {
+			return []WriteResult{}, fmt.Errorf("failed to restore subscription for podcast %v, rolled back: %v", r.PodcastID, err)
+		}
+
+		affected, _ := res.RowsAffected()
+
+		sub := CastopodSubscription{
+			PodcastID: r.PodcastID,
+			Email:     r.Email,
+			Token:     r.Token,
+			Status:    r.Status,
+			OldStatus: old,
+			Reason:    "rollback " + name,
+		}
+
+		if r.ExpiresAt != "" {
+			sub.ExpiresAt, _ = parseDatabaseTime(r.ExpiresAt)
+		}
+
+		results = append(results, WriteResult{Subscription: sub, RowsAffected: affected})
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return []WriteResult{}, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	runID := newRunID()
+	c.logger().Info("restored snapshot", "run_id", runID, "snapshot", name, "count", len(results))
+
+	err = c.WriteAuditLog(runID, now, results)
+	if err != nil {
+		return results, StageError{StageAudit, fmt.Errorf("subscriptions were restored, but failed to record them in the audit log: %v", err)}
+	}
+
+	return results, nil
+}
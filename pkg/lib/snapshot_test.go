@@ -0,0 +1,161 @@
+package ghosttocastopod_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	ghosttocastopod "github.com/charles-m-knox/ghost-to-castopod/pkg/lib"
+)
+
+func TestSnapshot(t *testing.T) {
+	t.Parallel()
+
+	const member = "member@example.com"
+
+	updated := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	expires := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	c := ghosttocastopod.Config{
+		Plans: map[string][]uint{"plan": {1, 2}},
+	}
+	c.ApplyDefaults()
+	c.CastopodConfig.UpdatedBy = 7
+
+	gms := []ghosttocastopod.GhostMembership{
+		{Email: member, Status: "canceled", PlanID: "plan"},
+		{Email: "new@example.com", Status: gActive, PlanID: "plan"},
+	}
+
+	cms := []ghosttocastopod.CastopodSubscription{
+		{ID: 3, Email: member, PodcastID: 1, Status: cActive, Token: "old", ExpiresAt: expires, UpdatedBy: 1, UpdatedAt: updated},
+		{ID: 4, Email: member, PodcastID: 2, Status: cSusp, Token: "old", UpdatedBy: 1, UpdatedAt: updated},
+	}
+
+	p := c.GetSyncPlan(gms, cms)
+	s := p.Snapshot(updated)
+
+	if len(s.Rows) != 1 || len(s.Created) != 2 {
+		t.Fatalf("unexpected snapshot: %+v", s)
+	}
+
+	want := ghosttocastopod.SnapshotRow{ID: 3, PodcastID: 1, Email: member, Token: "old", Status: cActive, ExpiresAt: "2024-02-01 00:00:00", UpdatedBy: 1, UpdatedAt: "2024-01-02 03:04:05"}
+	if s.Rows[0] != want {
+		t.Errorf("row mismatch, got %+v, want %+v", s.Rows[0], want)
+	}
+
+	if s.Filename() != "snapshot-20240102T030405Z.json" {
+		t.Errorf("unexpected filename %v", s.Filename())
+	}
+
+	f, err := s.WriteFile(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to write snapshot: %v", err)
+	}
+
+	fi, err := os.Stat(f)
+	if err != nil || fi.Mode().Perm() != 0o600 {
+		t.Errorf("snapshot should only be readable by its owner: %v %v", fi, err)
+	}
+
+	loaded, err := ghosttocastopod.LoadSnapshot(f)
+	if err != nil {
+		t.Fatalf("failed to load snapshot: %v", err)
+	}
+
+	if !loaded.TakenAt.Equal(s.TakenAt) || len(loaded.Rows) != 1 || loaded.Rows[0] != want || len(loaded.Created) != 2 {
+		t.Errorf("loaded snapshot mismatch, got %+v", loaded)
+	}
+
+	fdb := &fakeDB{
+		rows: map[string]*fakeRows{
+			"SELECT status FROM cp_subscriptions": {columns: []string{"status"}, values: [][]driver.Value{{cSusp}}},
+		},
+	}
+	db := fdb.open()
+	defer db.Close()
+
+	c.Audit.File = filepath.Join(t.TempDir(), "audit.jsonl")
+
+	results, err := c.RestoreSnapshot(context.Background(), db, loaded, filepath.Base(f))
+	if err != nil {
+		t.Fatalf("failed to restore snapshot: %v", err)
+	}
+
+	podcasts := ghosttocastopod.AffectedPodcasts(results)
+	if fmt.Sprint(podcasts) != "[1 2]" {
+		t.Errorf("podcasts mismatch, got %v", podcasts)
+	}
+
+	// the rollback is recorded in the audit log like any other change
+	af, err := os.Open(c.Audit.File)
+	if err != nil {
+		t.Fatalf("rollback was not recorded in the audit log: %v", err)
+	}
+	defer af.Close()
+
+	entries, err := ghosttocastopod.ReadAuditLog(af, ghosttocastopod.AuditFilter{Email: member, PodcastID: 1})
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("audit entries mismatch, got %+v, %v", entries, err)
+	}
+
+	e := entries[0]
+	if e.OldStatus != cSusp || e.NewStatus != cActive || e.ExpiresAt != "2024-02-01 00:00:00" || e.Cause != "rollback snapshot-20240102T030405Z.json" || e.RunID == "" {
+		t.Errorf("unexpected audit entry: %+v", e)
+	}
+
+	if len(fdb.execs) != 3 || fdb.commits != 1 {
+		t.Fatalf("unexpected execs: %+v, commits=%v", fdb.execs, fdb.commits)
+	}
+
+	restored := fdb.execs[0].args
+	if restored[0] != "old" || restored[1] != cActive || restored[2] != "2024-02-01 00:00:00" || restored[3] != int64(1) || restored[4] != "2024-01-02 03:04:05" || restored[5] != int64(1) || restored[6] != member {
+		t.Errorf("unexpected restore args: %v", restored)
+	}
+
+	for _, e := range fdb.execs[1:] {
+		if e.args[1] != cSusp || e.args[2] != nil || e.args[3] != int64(7) {
+			t.Errorf("created subscription was not suspended: %v", e.args)
+		}
+	}
+}
+
+func TestRestoreSnapshotRollback(t *testing.T) {
+	t.Parallel()
+
+	s := ghosttocastopod.Snapshot{
+		Rows: []ghosttocastopod.SnapshotRow{
+			{PodcastID: 1, Email: "foo@example.com", Status: cActive},
+			{PodcastID: 1, Email: "bar@example.com", Status: cActive},
+		},
+	}
+
+	f := &fakeDB{
+		rows: map[string]*fakeRows{
+			"SELECT status FROM cp_subscriptions": {columns: []string{"status"}, values: [][]driver.Value{{cActive}}},
+		},
+		failExec: func(_ string, args []driver.Value) error {
+			if args[6] == "bar@example.com" {
+				return fmt.Errorf("lock wait timeout")
+			}
+			return nil
+		},
+	}
+	db := f.open()
+	defer db.Close()
+
+	c := ghosttocastopod.Config{}
+
+	_, err := c.RestoreSnapshot(context.Background(), db, s, "snapshot.json")
+	if err == nil {
+		t.Fatalf("expected an error but did not receive one")
+	}
+
+	if f.commits != 0 || f.rollbacks != 1 {
+		t.Errorf("transaction mismatch, got commits=%v rollbacks=%v", f.commits, f.rollbacks)
+	}
+}
@@ -0,0 +1,160 @@
+package ghosttocastopod
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// GHOST_PLAN_QUERY lists every Stripe price that Ghost knows about, along
+// with the plan of every Stripe subscription, since subscriptions to prices
+// that were created outside of Ghost are not always in stripe_prices.
+const GHOST_PLAN_QUERY = `SELECT stripe_price_id FROM stripe_prices
+UNION
+SELECT plan_id FROM members_stripe_customers_subscriptions`
+
+// GHOST_TIER_QUERY lists every Ghost tier.
+const GHOST_TIER_QUERY = "SELECT id FROM products"
+
+// CASTOPOD_USER_QUERY lists every Castopod user.
+const CASTOPOD_USER_QUERY = "SELECT id FROM cp_users"
+
+// readIDs returns the first column of every row of query as a set.
+func readIDs(ctx context.Context, db *sql.DB, query string) (map[string]bool, error) {
+	ids := make(map[string]bool)
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return ids, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var id sql.NullString
+
+		err := rows.Scan(&id)
+		if err != nil {
+			return ids, fmt.Errorf("failed to scan row: %v", err)
+		}
+
+		if id.Valid {
+			ids[id.String] = true
+		}
+	}
+
+	return ids, rows.Err()
+}
+
+// sortedKeys returns the keys of m in order, so that problems are reported in
+// a stable order.
+func sortedKeys[K cmp.Ordered, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	slices.Sort(keys)
+
+	return keys
+}
+
+// ValidateAgainstDatabases checks the config against the live databases,
+// which [Config.Validate] can't: that every plan in Plans exists in Ghost,
+// that every tier in Tiers exists in Ghost, that every configured podcast
+// exists in Castopod, and that CreatedBy and UpdatedBy are Castopod users. It
+// also resolves the config's podcast handles with
+// [Config.ResolvePodcastHandles]. Every problem is reported in the returned
+// error, not just the first.
+//
+// If ghost is nil, such as when memberships are read from the Ghost Admin API,
+// plans and tiers are not checked.
+func (c *Config) ValidateAgainstDatabases(ctx context.Context, ghost, castopod *sql.DB) error {
+	errs := []error{}
+
+	err := c.ResolvePodcastHandles(ctx, castopod)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	if ghost != nil {
+		checks := []struct {
+			name  string
+			query string
+			keys  []string
+		}{
+			{"plans", GHOST_PLAN_QUERY, sortedKeys(c.Plans)},
+			{"tiers", GHOST_TIER_QUERY, sortedKeys(c.Tiers)},
+		}
+
+		for _, check := range checks {
+			ids, err := readIDs(ctx, ghost, check.query)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%v: failed to read them from ghost: %v", check.name, err))
+				continue
+			}
+
+			for _, k := range check.keys {
+				if !ids[k] {
+					errs = append(errs, fmt.Errorf("%v: %v does not exist in ghost", check.name, k))
+				}
+			}
+		}
+	}
+
+	ps, err := ReadCastopodPodcasts(ctx, castopod)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("podcasts: %v", err))
+	} else {
+		exists := func(id uint) bool {
+			return slices.ContainsFunc(ps, func(p CastopodPodcast) bool { return p.ID == id })
+		}
+
+		check := func(field string, ids []uint) {
+			for _, id := range ids {
+				if !exists(id) {
+					errs = append(errs, fmt.Errorf("%v: podcast %v does not exist in castopod", field, id))
+				}
+			}
+		}
+
+		for _, k := range sortedKeys(c.Plans) {
+			check("plans."+k, c.Plans[k])
+		}
+
+		for _, k := range sortedKeys(c.Tiers) {
+			check("tiers."+k, c.Tiers[k])
+		}
+
+		for _, k := range sortedKeys(c.BlessedAccounts) {
+			check("blessedAccounts."+k, c.BlessedAccounts[k])
+		}
+
+		check("castopodConfig.managedPodcasts", c.CastopodConfig.ManagedPodcasts)
+		check("notifications.templates", sortedKeys(c.Notifications.Templates))
+	}
+
+	users, err := readIDs(ctx, castopod, CASTOPOD_USER_QUERY)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("castopodConfig: failed to read users from castopod: %v", err))
+	} else {
+		fields := []struct {
+			name string
+			id   uint
+		}{
+			{"castopodConfig.createdBy", c.CastopodConfig.CreatedBy},
+			{"castopodConfig.updatedBy", c.CastopodConfig.UpdatedBy},
+		}
+
+		for _, f := range fields {
+			if !users[fmt.Sprint(f.id)] {
+				errs = append(errs, fmt.Errorf("%v: castopod user %v does not exist", f.name, f.id))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,103 @@
+package ghosttocastopod_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	ghosttocastopod "github.com/charles-m-knox/ghost-to-castopod/pkg/lib"
+)
+
+func TestValidateAgainstDatabases(t *testing.T) {
+	t.Parallel()
+
+	ghost := &fakeDB{
+		rows: map[string]*fakeRows{
+			"stripe_prices": {columns: []string{"stripe_price_id"}, values: [][]driver.Value{{"price_a"}, {"price_b"}, {nil}}},
+			"FROM products": {columns: []string{"id"}, values: [][]driver.Value{{"tier_a"}}},
+		},
+	}
+
+	castopod := newPodcastsDB()
+	castopod.rows["cp_users"] = &fakeRows{columns: []string{"id"}, values: [][]driver.Value{{int64(1)}}}
+
+	gdb := ghost.open()
+	defer gdb.Close()
+
+	cdb := castopod.open()
+	defer cdb.Close()
+
+	valid := `{
+		"plans": {"price_a": [3], "price_b": ["@bar"]},
+		"tiers": {"tier_a": [4]},
+		"blessedAccounts": {"admin@example.com": [5]}
+	}`
+
+	invalid := `{
+		"plans": {"price_a": [3], "price_typo": [9]},
+		"tiers": {"tier_typo": [4]},
+		"blessedAccounts": {"admin@example.com": ["@missing"]},
+		"castopodConfig": {"managedPodcasts": [3, 8], "updatedBy": 2},
+		"notifications": {"templates": {"7": {"feedUrl": "https://example.com/feed"}}}
+	}`
+
+	c, err := loadConfigJSON(valid)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	err = c.ValidateAgainstDatabases(context.Background(), gdb, cdb)
+	if err != nil {
+		t.Errorf("expected the config to be valid, got %v", err)
+	}
+
+	c, err = loadConfigJSON(invalid)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	err = c.ValidateAgainstDatabases(context.Background(), gdb, cdb)
+	if err == nil {
+		t.Fatalf("expected the config to be invalid")
+	}
+
+	want := []string{
+		"unknown podcast handle @missing",
+		"plans: price_typo does not exist in ghost",
+		"tiers: tier_typo does not exist in ghost",
+		"plans.price_typo: podcast 9 does not exist in castopod",
+		"castopodConfig.managedPodcasts: podcast 8 does not exist in castopod",
+		"notifications.templates: podcast 7 does not exist in castopod",
+		"castopodConfig.updatedBy: castopod user 2 does not exist",
+	}
+
+	got := strings.Split(err.Error(), "\n")
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("problems mismatch, got:\n%v\nwant:\n%v", err, strings.Join(want, "\n"))
+	}
+
+	// without a ghost database, only castopod is checked
+	c, _ = loadConfigJSON(`{"plans": {"price_typo": [3]}}`)
+
+	err = c.ValidateAgainstDatabases(context.Background(), nil, cdb)
+	if err != nil {
+		t.Errorf("expected plans not to be checked without a ghost database, got %v", err)
+	}
+}
+
+// loadConfigJSON unmarshals a config and applies its defaults, like
+// [ghosttocastopod.LoadConfig] without the file.
+func loadConfigJSON(s string) (*ghosttocastopod.Config, error) {
+	var c ghosttocastopod.Config
+
+	err := json.Unmarshal([]byte(s), &c)
+	if err != nil {
+		return nil, err
+	}
+
+	c.ApplyDefaults()
+
+	return &c, nil
+}
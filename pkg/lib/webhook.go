@@ -0,0 +1,259 @@
+package ghosttocastopod
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Configuration for receiving Ghost's member.added, member.edited and
+// member.deleted webhooks.
+type WebhookConfig struct {
+	// The address to listen on, such as ":8080".
+	Listen string `json:"listen"`
+	// The secret that was configured for the webhooks in Ghost admin. Every
+	// request's X-Ghost-Signature header is verified against it. It is
+	// required when Listen is set, and [WebhookHandler] rejects every request
+	// without it, since anyone can sign a payload with an empty key.
+	Secret string `json:"secret" redact:"true"`
+	// How long a webhook waits for a sync, or another webhook, to release the
+	// run lock from [CastopodConfig.Lock] before giving up. A webhook that
+	// gives up is answered with 503 Service Unavailable, and the member is
+	// reconciled by the next full sync instead. Defaults to
+	// [DefaultWebhookLockTimeout].
+	LockTimeout Duration `json:"lockTimeout"`
+}
+
+const DefaultWebhookLockTimeout = 10 * time.Second
+
+const (
+	// The header that Ghost uses to sign webhook payloads.
+	GhostSignatureHeader = "X-Ghost-Signature"
+	// Webhook signatures whose timestamp differs from the current time by more
+	// than this are rejected, to limit replays.
+	GhostSignatureTolerance = 5 * time.Minute
+	// The maximum accepted size of a webhook request body.
+	webhookMaxBodyBytes = 1 << 20
+)
+
+// GhostStatusDeleted is assigned to the memberships of a member that was
+// deleted from Ghost, so that their subscriptions are suspended.
+const GhostStatusDeleted = "deleted"
+
+// SignGhostWebhook produces an X-Ghost-Signature header value for body, in the
+// same way that Ghost does: an HMAC-SHA256 of the body followed by the
+// timestamp in milliseconds.
+func SignGhostWebhook(secret string, body []byte, t time.Time) string {
+	ts := strconv.FormatInt(t.UnixMilli(), 10)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	mac.Write([]byte(ts))
+
+	return fmt.Sprintf("sha256=%v, t=%v", hex.EncodeToString(mac.Sum(nil)), ts)
+}
+
+// ErrNoWebhookSecret is returned when a webhook is verified without a secret.
+var ErrNoWebhookSecret = errors.New("no webhook secret is configured")
+
+// VerifyGhostWebhook checks that header is a valid X-Ghost-Signature for body,
+// and that it was produced within [GhostSignatureTolerance] of now. It returns
+// [ErrNoWebhookSecret] if secret is empty.
+func VerifyGhostWebhook(secret string, body []byte, header string, now time.Time) error {
+	if secret == "" {
+		return ErrNoWebhookSecret
+	}
+
+	var sig, ts string
+
+	for _, part := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch k {
+		case "sha256":
+			sig = v
+		case "t":
+			ts = v
+		}
+	}
+
+	if sig == "" || ts == "" {
+		return fmt.Errorf("malformed signature header")
+	}
+
+	ms, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return fmt.Errorf("malformed signature timestamp: %v", err)
+	}
+
+	d := now.Sub(time.UnixMilli(ms))
+	if d > GhostSignatureTolerance || d < -GhostSignatureTolerance {
+		return fmt.Errorf("signature timestamp is outside of the allowed tolerance")
+	}
+
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return fmt.Errorf("malformed signature: %v", err)
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	mac.Write([]byte(ts))
+
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return fmt.Errorf("signature mismatch")
+	}
+
+	return nil
+}
+
+// ghostWebhookPayload is the body of every member.* webhook. For
+// member.deleted, Current is empty and Previous holds the deleted member.
+type ghostWebhookPayload struct {
+	Member struct {
+		Current  ghostAPIMember `json:"current"`
+		Previous ghostAPIMember `json:"previous"`
+	} `json:"member"`
+}
+
+// WebhookHandler is an [http.Handler] that receives Ghost member webhooks and
+// reconciles the affected member's Castopod subscriptions.
+type WebhookHandler struct {
+	Config *Config
+	// Sync is called once for each email address affected by a webhook, with
+	// that email's current Ghost memberships.
+	Sync func(ctx context.Context, email string, gms []GhostMembership) error
+}
+
+// NewWebhookHandler returns a [WebhookHandler] that reconciles members against
+// the Castopod database db using [Config.SyncMember].
+func (c *Config) NewWebhookHandler(db *sql.DB) *WebhookHandler {
+	return &WebhookHandler{
+		Config: c,
+		Sync: func(ctx context.Context, email string, gms []GhostMembership) error {
+			_, err := c.SyncMember(ctx, db, email, gms)
+			return err
+		},
+	}
+}
+
+func (h *WebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	log := h.Config.logger()
+
+	// fail closed, since a signature made with an empty secret proves nothing
+	if h.Config.Webhooks.Secret == "" {
+		log.Error("rejected ghost webhook", "remote", r.RemoteAddr, "err", ErrNoWebhookSecret)
+		http.Error(w, "webhooks are not configured", http.StatusInternalServerError)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, webhookMaxBodyBytes))
+	if err != nil {
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+		return
+	}
+
+	err = VerifyGhostWebhook(h.Config.Webhooks.Secret, body, r.Header.Get(GhostSignatureHeader), time.Now())
+	if err != nil {
+		log.Warn("rejected ghost webhook", "remote", r.RemoteAddr, "err", err)
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	var p ghostWebhookPayload
+	err = json.Unmarshal(body, &p)
+	if err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	cur, prev := p.Member.Current, p.Member.Previous
+
+	if cur.Email != "" {
+		log.Info("received ghost webhook", LogKeyEmail, cur.Email)
+
+		err = h.Sync(r.Context(), cur.Email, cur.memberships(""))
+		if err != nil {
+			syncError(w, log, cur.Email, err)
+			return
+		}
+	}
+
+	// member.deleted, or member.edited where the email address changed: the
+	// previous email address should no longer have access.
+	if prev.Email != "" && prev.Email != cur.Email {
+		log.Info("received ghost webhook for a previous email address", LogKeyEmail, prev.Email)
+
+		err = h.Sync(r.Context(), prev.Email, prev.memberships(GhostStatusDeleted))
+		if err != nil {
+			syncError(w, log, prev.Email, err)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// syncError logs a failure to sync the member with the given email and
+// responds to the webhook. If another run held the lock, the response is 503
+// Service Unavailable, since the member will be reconciled by a later sync.
+func syncError(w http.ResponseWriter, log *slog.Logger, email string, err error) {
+	if errors.Is(err, ErrLockHeld) {
+		log.Warn("another run holds the lock, leaving the member to the next sync", LogKeyEmail, email, "err", err)
+		http.Error(w, "another sync is running", http.StatusServiceUnavailable)
+
+		return
+	}
+
+	log.Error("failed to sync member", LogKeyEmail, email, "err", err)
+	http.Error(w, "failed to sync member", http.StatusInternalServerError)
+}
+
+// SyncMember reconciles the Castopod subscriptions for a single email address
+// against gms, which should contain only that email's Ghost memberships, and
+// writes any changes using [Config.ApplyCastopodSubscriptions].
+//
+// It holds the run lock from [CastopodConfig.Lock] while it reads and writes,
+// like a full sync, so that it can't race a sync on another host and issue
+// competing tokens for the same member. It waits up to
+// [WebhookConfig.LockTimeout] for the lock; if it is still held, the returned
+// error wraps [ErrLockHeld].
+func (c *Config) SyncMember(ctx context.Context, db *sql.DB, email string, gms []GhostMembership) ([]WriteResult, error) {
+	l, err := c.acquireRunLock(ctx, db, c.Webhooks.LockTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		err := l.Release(ctx)
+		if err != nil {
+			c.logger().Warn("failed to release lock", "err", err)
+		}
+	}()
+
+	cms, err := c.ReadCastopodSubscriptionsByEmail(ctx, db, email)
+	if err != nil {
+		return nil, err
+	}
+
+	// blessed accounts are always included in the reconciliation, so only keep
+	// the rows that belong to this member
+	subs := c.GetSyncPlan(gms, cms).ForEmail(email).Subscriptions()
+
+	return c.ApplyCastopodSubscriptions(ctx, db, subs)
+}
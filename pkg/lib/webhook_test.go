@@ -0,0 +1,259 @@
+package ghosttocastopod_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	ghosttocastopod "github.com/charles-m-knox/ghost-to-castopod/pkg/lib"
+)
+
+func TestVerifyGhostWebhook(t *testing.T) {
+	t.Parallel()
+
+	const secret = "webhook-secret"
+	body := []byte(`{"member":{}}`)
+	now := time.Now()
+
+	tests := []struct {
+		secret string
+		header string
+		err    bool
+	}{
+		{secret, ghosttocastopod.SignGhostWebhook(secret, body, now), false},
+		{"wrong", ghosttocastopod.SignGhostWebhook(secret, body, now), true},
+		{secret, ghosttocastopod.SignGhostWebhook(secret, body, now.Add(-time.Hour)), true},
+		{secret, "", true},
+		{secret, "sha256=zz, t=1", true},
+		// anyone can sign with an empty secret
+		{"", ghosttocastopod.SignGhostWebhook("", body, now), true},
+	}
+
+	for i, test := range tests {
+		err := ghosttocastopod.VerifyGhostWebhook(test.secret, body, test.header, now)
+		if err != nil && !test.err {
+			t.Errorf("test %v failed: received unexpected err: %v", i, err)
+		} else if err == nil && test.err {
+			t.Errorf("test %v failed: did not receive error but wanted one", i)
+		}
+	}
+}
+
+func TestWebhookHandler(t *testing.T) {
+	t.Parallel()
+
+	const secret = "webhook-secret"
+
+	type call struct {
+		email string
+		gms   []ghosttocastopod.GhostMembership
+	}
+
+	tests := []struct {
+		body   string
+		sign   bool
+		status int
+		want   []call
+	}{
+		// member.added
+		{
+			`{"member":{"current":{"email":"foo@example.com","subscriptions":[{"status":"active","plan":{"id":"plan1"}}]},"previous":{}}}`,
+			true, http.StatusNoContent,
+			[]call{{"foo@example.com", []ghosttocastopod.GhostMembership{{Email: "foo@example.com", Status: gActive, PlanID: "plan1"}}}},
+		},
+		// member.edited with an email change
+		{
+			`{"member":{"current":{"email":"new@example.com","subscriptions":[{"status":"active","plan":{"id":"plan1"}}]},"previous":{"email":"old@example.com","subscriptions":[{"status":"active","plan":{"id":"plan1"}}]}}}`,
+			true, http.StatusNoContent,
+			[]call{
+				{"new@example.com", []ghosttocastopod.GhostMembership{{Email: "new@example.com", Status: gActive, PlanID: "plan1"}}},
+				{"old@example.com", []ghosttocastopod.GhostMembership{{Email: "old@example.com", Status: ghosttocastopod.GhostStatusDeleted, PlanID: "plan1"}}},
+			},
+		},
+		// member.deleted
+		{
+			`{"member":{"current":{},"previous":{"email":"foo@example.com","subscriptions":[{"status":"active","plan":{"id":"plan1"}}]}}}`,
+			true, http.StatusNoContent,
+			[]call{{"foo@example.com", []ghosttocastopod.GhostMembership{{Email: "foo@example.com", Status: ghosttocastopod.GhostStatusDeleted, PlanID: "plan1"}}}},
+		},
+		// unsigned
+		{`{"member":{"current":{"email":"foo@example.com"}}}`, false, http.StatusUnauthorized, nil},
+	}
+
+	for i, test := range tests {
+		calls := []call{}
+
+		c := &ghosttocastopod.Config{Webhooks: ghosttocastopod.WebhookConfig{Secret: secret}}
+		h := &ghosttocastopod.WebhookHandler{
+			Config: c,
+			Sync: func(_ context.Context, email string, gms []ghosttocastopod.GhostMembership) error {
+				calls = append(calls, call{email, gms})
+				return nil
+			},
+		}
+
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(test.body))
+		if test.sign {
+			req.Header.Set(ghosttocastopod.GhostSignatureHeader, ghosttocastopod.SignGhostWebhook(secret, []byte(test.body), time.Now()))
+		}
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != test.status {
+			t.Errorf("test %v failed: status mismatch, got %v, want %v", i, rec.Code, test.status)
+		}
+
+		if len(calls) != len(test.want) {
+			t.Errorf("test %v failed: sync call count mismatch, got %v, want %v", i, calls, test.want)
+			continue
+		}
+
+		for j := range calls {
+			if calls[j].email != test.want[j].email || len(calls[j].gms) != len(test.want[j].gms) {
+				t.Errorf("test %v failed: call %v mismatch, got %v, want %v", i, j, calls[j], test.want[j])
+				continue
+			}
+
+			for k := range calls[j].gms {
+				if calls[j].gms[k] != test.want[j].gms[k] {
+					t.Errorf("test %v failed: call %v membership mismatch, got %v, want %v", i, j, calls[j].gms[k], test.want[j].gms[k])
+				}
+			}
+		}
+	}
+}
+
+func TestWebhookHandlerNoSecret(t *testing.T) {
+	t.Parallel()
+
+	const body = `{"member":{"current":{"email":"foo@example.com","subscriptions":[{"status":"active","plan":{"id":"plan1"}}]},"previous":{}}}`
+
+	synced := false
+
+	h := &ghosttocastopod.WebhookHandler{
+		Config: &ghosttocastopod.Config{},
+		Sync: func(context.Context, string, []ghosttocastopod.GhostMembership) error {
+			synced = true
+			return nil
+		},
+	}
+
+	// a payload signed with the empty secret must not be trusted
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.Header.Set(ghosttocastopod.GhostSignatureHeader, ghosttocastopod.SignGhostWebhook("", []byte(body), time.Now()))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusInternalServerError || synced {
+		t.Errorf("expected the webhook to be rejected, got status %v and synced %v", rec.Code, synced)
+	}
+
+	c := ghosttocastopod.Config{Webhooks: ghosttocastopod.WebhookConfig{Listen: ":8080"}}
+	c.ApplyDefaults()
+
+	err := c.Validate()
+	if err == nil || !strings.Contains(err.Error(), "webhooks.secret") {
+		t.Errorf("expected validation to require webhooks.secret, got %v", err)
+	}
+}
+
+func TestSyncMember(t *testing.T) {
+	t.Parallel()
+
+	const email = "foo@example.com"
+
+	c := ghosttocastopod.Config{
+		Plans:           map[string][]uint{"plan1": {1, 2}},
+		BlessedAccounts: map[string][]uint{"admin@example.com": {1}},
+	}
+	c.ApplyDefaults()
+
+	f := &fakeDB{
+		rows: map[string]*fakeRows{
+			"GET_LOCK": {columns: []string{"GET_LOCK"}, values: [][]driver.Value{{int64(1)}}},
+			"FROM cp_subscriptions WHERE email = ?": {
+				columns: []string{"id", "podcast_id", "email", "token", "status", "expires_at", "created_by", "updated_by", "created_at", "updated_at"},
+				values: [][]driver.Value{
+					{int64(1), int64(1), email, "token1", cActive, nil, int64(1), int64(1), "2024-08-01 10:00:00", "2024-08-01 10:00:00"},
+				},
+			},
+		},
+	}
+	db := f.open()
+	defer db.Close()
+
+	gms := []ghosttocastopod.GhostMembership{{Email: email, Status: gActive, PlanID: "plan1"}}
+
+	results, err := c.SyncMember(context.Background(), db, email, gms)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	// podcast 1 already exists and is active, so only podcast 2 is written;
+	// the blessed account must not be touched
+	if len(results) != 1 {
+		t.Fatalf("result length mismatch, got %v, want %v", len(results), 1)
+	}
+
+	if results[0].Subscription.Email != email || results[0].Subscription.PodcastID != 2 {
+		t.Errorf("unexpected result: %+v", results[0].Subscription)
+	}
+
+	// the run lock is held around the write, like a full sync
+	if len(f.execs) == 0 || !strings.Contains(f.execs[len(f.execs)-1].query, "RELEASE_LOCK") {
+		t.Errorf("lock was not released after the write: %+v", f.execs)
+	}
+}
+
+func TestSyncMemberLockHeld(t *testing.T) {
+	t.Parallel()
+
+	const (
+		email  = "foo@example.com"
+		secret = "webhook-secret"
+	)
+
+	c := ghosttocastopod.Config{
+		Plans:    map[string][]uint{"plan1": {1}},
+		Webhooks: ghosttocastopod.WebhookConfig{Secret: secret, LockTimeout: ghosttocastopod.Duration(time.Second)},
+	}
+	c.ApplyDefaults()
+
+	f := &fakeDB{
+		rows: map[string]*fakeRows{
+			"GET_LOCK": {columns: []string{"GET_LOCK"}, values: [][]driver.Value{{int64(0)}}},
+		},
+	}
+	db := f.open()
+	defer db.Close()
+
+	gms := []ghosttocastopod.GhostMembership{{Email: email, Status: gActive, PlanID: "plan1"}}
+
+	_, err := c.SyncMember(context.Background(), db, email, gms)
+	if !errors.Is(err, ghosttocastopod.ErrLockHeld) {
+		t.Errorf("expected ErrLockHeld, got %v", err)
+	}
+
+	if len(f.execs) != 0 {
+		t.Errorf("wrote while another run held the lock: %+v", f.execs)
+	}
+
+	// the webhook is answered with 503, leaving the member to the next sync
+	body := `{"member":{"current":{"email":"foo@example.com","subscriptions":[{"status":"active","plan":{"id":"plan1"}}]},"previous":{}}}`
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.Header.Set(ghosttocastopod.GhostSignatureHeader, ghosttocastopod.SignGhostWebhook(secret, []byte(body), time.Now()))
+
+	rec := httptest.NewRecorder()
+	c.NewWebhookHandler(db).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status mismatch, got %v, want %v", rec.Code, http.StatusServiceUnavailable)
+	}
+}
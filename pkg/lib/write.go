@@ -0,0 +1,166 @@
+package ghosttocastopod
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// CASTOPOD_SUBSCRIPTION_UPSERT is the prepared statement used by
+// [WriteCastopodSubscriptions]. Every value is passed as a placeholder
+// argument, so nothing from Ghost is ever interpolated into the query.
+const CASTOPOD_SUBSCRIPTION_UPSERT = `INSERT INTO cp_subscriptions (podcast_id, email, token, status, expires_at, created_by, updated_by, created_at, updated_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON DUPLICATE KEY UPDATE token = VALUES(token), status = VALUES(status), expires_at = VALUES(expires_at), updated_by = VALUES(updated_by), updated_at = VALUES(updated_at)`
+
+// CastopodTimeFormat is the layout that Castopod's datetime columns use.
+const CastopodTimeFormat = "2006-01-02 15:04:05"
+
+// WriteResult describes the outcome of writing a single changed
+// [CastopodSubscription] to the Castopod database.
+type WriteResult struct {
+	Subscription CastopodSubscription
+	// The number of rows affected, as reported by the driver. For mysql's
+	// ON DUPLICATE KEY UPDATE, 1 means inserted, 2 means updated and 0 means
+	// the row already matched.
+	RowsAffected int64
+	// Non-nil if this row failed to write. When any row fails, the entire
+	// transaction is rolled back.
+	Err error
+}
+
+// Args returns the placeholder arguments for [CASTOPOD_SUBSCRIPTION_UPSERT],
+// in order.
+func (s CastopodSubscription) Args() []any {
+	var expiresAt any
+	if !s.ExpiresAt.IsZero() {
+		expiresAt = s.ExpiresAt.Format(CastopodTimeFormat)
+	}
+
+	return []any{
+		s.PodcastID,
+		s.Email,
+		s.Token,
+		s.Status,
+		expiresAt,
+		s.CreatedBy,
+		s.UpdatedBy,
+		s.CreatedAt.Format(CastopodTimeFormat),
+		s.UpdatedAt.Format(CastopodTimeFormat),
+	}
+}
+
+// WriteCastopodSubscriptions writes every subscription in subs that has
+// Changed set to true, typically the output of [Config.GetCastopodSubscriptions].
+// All rows are written with a single prepared statement inside one
+// transaction; if any row fails, the transaction is rolled back and the
+// returned error is non-nil. The returned results contain one entry per row
+// that was attempted.
+func WriteCastopodSubscriptions(ctx context.Context, db *sql.DB, subs []CastopodSubscription) ([]WriteResult, error) {
+	results := []WriteResult{}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return results, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+
+	// Rollback is a no-op once the transaction has been committed.
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, CASTOPOD_SUBSCRIPTION_UPSERT)
+	if err != nil {
+		return results, fmt.Errorf("failed to prepare upsert statement: %v", err)
+	}
+
+	defer stmt.Close()
+
+	for _, s := range subs {
+		if !s.Changed {
+			continue
+		}
+
+		r := WriteResult{Subscription: s}
+
+		res, err := stmt.ExecContext(ctx, s.Args()...)
+		if err == nil {
+			r.RowsAffected, err = res.RowsAffected()
+		}
+
+		if err != nil {
+			r.Err = err
+			results = append(results, r)
+
+			return results, fmt.Errorf("failed to write subscription for podcast %v, rolled back: %v", s.PodcastID, err)
+		}
+
+		results = append(results, r)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return results, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	return results, nil
+}
+
+// ApplyCastopodSubscriptions writes subs using [WriteCastopodSubscriptions].
+// If the write succeeded, it then records the changes in the audit log when
+// [Config.Audit] is configured, invalidates Castopod's cache for the affected
+// podcasts when [CastopodConfig.Redis] is configured, so that the changes
+// take effect immediately, and emails the new and reactivated subscribers
+// when [Config.Notifications] is configured.
+func (c *Config) ApplyCastopodSubscriptions(ctx context.Context, db *sql.DB, subs []CastopodSubscription) ([]WriteResult, error) {
+	err := c.checkPodcastHandles()
+	if err != nil {
+		return []WriteResult{}, StageError{StageWrite, err}
+	}
+
+	runID := newRunID()
+	log := c.logger().With("run_id", runID)
+
+	results, err := WriteCastopodSubscriptions(ctx, db, subs)
+	if err != nil {
+		return results, StageError{StageWrite, err}
+	}
+
+	for _, r := range results {
+		log.Debug("wrote subscription", LogKeyEmail, r.Subscription.Email, "podcast_id", r.Subscription.PodcastID, "status", r.Subscription.Status, "rows_affected", r.RowsAffected)
+	}
+
+	log.Info("wrote subscriptions", "count", len(results))
+
+	errs := []error{}
+
+	err = c.WriteAuditLog(runID, time.Now(), results)
+	if err != nil {
+		errs = append(errs, StageError{StageAudit, fmt.Errorf("subscriptions were written, but failed to record them in the audit log: %v", err)})
+	}
+
+	podcasts := AffectedPodcasts(results)
+
+	deleted, err := c.InvalidateCastopodCache(ctx, podcasts)
+	if err == nil && c.CastopodConfig.Redis.Addr != "" {
+		log.Info("invalidated castopod cache", "podcasts", podcasts, "keys", deleted)
+	} else if err != nil {
+		errs = append(errs, StageError{StageInvalidateCache, fmt.Errorf("subscriptions were written, but failed to invalidate the castopod cache: %v", err)})
+	}
+
+	ns, err := c.GetNotifications(results)
+	if err != nil {
+		errs = append(errs, StageError{StageNotify, fmt.Errorf("subscriptions were written, but failed to render notifications: %v", err)})
+	}
+
+	sent, err := c.SendNotifications(ctx, ns)
+	if sent > 0 {
+		log.Info("sent notifications", "count", sent)
+	}
+
+	if err != nil {
+		errs = append(errs, StageError{StageNotify, fmt.Errorf("subscriptions were written, but failed to send notifications: %v", err)})
+	}
+
+	return results, errors.Join(errs...)
+}
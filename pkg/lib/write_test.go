@@ -0,0 +1,96 @@
+package ghosttocastopod_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"testing"
+
+	ghosttocastopod "github.com/charles-m-knox/ghost-to-castopod/pkg/lib"
+)
+
+func TestWriteCastopodSubscriptions(t *testing.T) {
+	t.Parallel()
+
+	// an email containing a quote would have broken the old string-built query
+	const tricky = "o'brien@example.com"
+
+	subs := []ghosttocastopod.CastopodSubscription{
+		{Email: tricky, PodcastID: 1, Status: cActive, Changed: true},
+		{Email: "unchanged@example.com", PodcastID: 1, Status: cActive, Changed: false},
+		{Email: "baz@example.com", PodcastID: 2, Status: cSusp, Changed: true},
+	}
+
+	f := &fakeDB{}
+	db := f.open()
+	defer db.Close()
+
+	results, err := ghosttocastopod.WriteCastopodSubscriptions(context.Background(), db, subs)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	if len(results) != 2 {
+		t.Fatalf("result length mismatch, got %v, want %v", len(results), 2)
+	}
+
+	if len(f.execs) != 2 {
+		t.Fatalf("exec count mismatch, got %v, want %v", len(f.execs), 2)
+	}
+
+	if f.execs[0].query != ghosttocastopod.CASTOPOD_SUBSCRIPTION_UPSERT {
+		t.Errorf("unexpected query: %v", f.execs[0].query)
+	}
+
+	if f.execs[0].args[1] != tricky {
+		t.Errorf("email arg mismatch, got %v, want %v", f.execs[0].args[1], tricky)
+	}
+
+	if f.commits != 1 || f.rollbacks != 0 {
+		t.Errorf("transaction mismatch, got commits=%v rollbacks=%v", f.commits, f.rollbacks)
+	}
+
+	for i, r := range results {
+		if r.Err != nil || r.RowsAffected != 1 {
+			t.Errorf("result %v mismatch: %+v", i, r)
+		}
+	}
+}
+
+func TestWriteCastopodSubscriptionsRollback(t *testing.T) {
+	t.Parallel()
+
+	subs := []ghosttocastopod.CastopodSubscription{
+		{Email: "foo@example.com", PodcastID: 1, Status: cActive, Changed: true},
+		{Email: "bar@example.com", PodcastID: 1, Status: cActive, Changed: true},
+		{Email: "baz@example.com", PodcastID: 1, Status: cActive, Changed: true},
+	}
+
+	f := &fakeDB{
+		failExec: func(_ string, args []driver.Value) error {
+			if args[1] == "bar@example.com" {
+				return fmt.Errorf("duplicate token")
+			}
+			return nil
+		},
+	}
+	db := f.open()
+	defer db.Close()
+
+	results, err := ghosttocastopod.WriteCastopodSubscriptions(context.Background(), db, subs)
+	if err == nil {
+		t.Fatalf("expected an error but did not receive one")
+	}
+
+	if len(results) != 2 {
+		t.Fatalf("result length mismatch, got %v, want %v", len(results), 2)
+	}
+
+	if results[0].Err != nil || results[1].Err == nil {
+		t.Errorf("unexpected per-row errors: %+v", results)
+	}
+
+	if f.commits != 0 || f.rollbacks != 1 {
+		t.Errorf("transaction mismatch, got commits=%v rollbacks=%v", f.commits, f.rollbacks)
+	}
+}